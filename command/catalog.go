@@ -0,0 +1,57 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/drone-runners/drone-runner-aws/internal/catalog"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type catalogCommand struct {
+	Region   string
+	Endpoint string
+	Output   string
+}
+
+func (c *catalogCommand) run(*kingpin.ParseContext) error {
+	types, err := catalog.Fetch(nocontext, c.Region, c.Endpoint)
+	if err != nil {
+		return err
+	}
+	if len(types) == 0 {
+		return fmt.Errorf("no instance types found in region %s", c.Region)
+	}
+	out, err := catalog.Generate(types)
+	if err != nil {
+		return err
+	}
+	if c.Output == "" {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	return ioutil.WriteFile(c.Output, out, 0644)
+}
+
+func registerCatalog(app *kingpin.Application) {
+	c := new(catalogCommand)
+
+	cmd := app.Command("catalog", "refresh the embedded instance type catalog").
+		Action(c.run)
+
+	cmd.Flag("region", "region used to query the instance types").
+		Default("us-east-1").
+		StringVar(&c.Region)
+
+	cmd.Flag("endpoint", "ec2 endpoint").
+		StringVar(&c.Endpoint)
+
+	cmd.Flag("output", "output file, defaults to stdout").
+		StringVar(&c.Output)
+}
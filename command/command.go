@@ -23,9 +23,11 @@ var nocontext = context.Background()
 // subcommand program.
 func Command() {
 	app := kingpin.New("drone", "drone aws runner")
+	registerCatalog(app)
 	registerCompile(app)
 	registerExec(app)
 	daemon.Register(app)
+	daemon.RegisterValidate(app)
 
 	kingpin.Version(version)
 	kingpin.MustParse(app.Parse(os.Args[1:]))
@@ -6,15 +6,19 @@ package command
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
 
 	"github.com/drone-runners/drone-runner-aws/command/internal"
+	"github.com/drone-runners/drone-runner-aws/engine"
 	"github.com/drone-runners/drone-runner-aws/engine/compiler"
 	"github.com/drone-runners/drone-runner-aws/engine/linter"
 	"github.com/drone-runners/drone-runner-aws/engine/resource"
+	"github.com/drone-runners/drone-runner-aws/internal/account"
+	"github.com/drone-runners/drone-runner-aws/internal/pool"
 	"github.com/drone/envsubst"
 	"github.com/drone/runner-go/environ"
 	"github.com/drone/runner-go/environ/provider"
@@ -28,10 +32,13 @@ import (
 type compileCommand struct {
 	*internal.Flags
 
-	Source     *os.File
-	Environ    map[string]string
-	Secrets    map[string]string
-	Settings   compiler.Settings
+	Source      *os.File
+	Environ     map[string]string
+	Secrets     map[string]string
+	Settings    compiler.Settings
+	Strict      bool
+	PoolFile    string
+	AccountFile string
 }
 
 func (c *compileCommand) run(*kingpin.ParseContext) error {
@@ -75,15 +82,44 @@ func (c *compileCommand) run(*kingpin.ParseContext) error {
 	}
 
 	// a configuration can contain multiple pipelines.
-	// get a specific pipeline resource for execution.
-	resource, err := resource.Lookup(c.Stage.Name, manifest)
+	// get a specific pipeline resource for execution. strict
+	// parsing reports unknown yaml fields, which are otherwise
+	// ignored.
+	resource, err := resource.Lookup(c.Stage.Name, manifest,
+		resource.WithStrict(c.Strict),
+		resource.WithConfig(config),
+	)
 	if err != nil {
 		return err
 	}
 
+	// the named instance pools can be sourced from a
+	// separate file.
+	var pools pool.Pools
+	if c.PoolFile != "" {
+		pools, err = pool.Load(c.PoolFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the named accounts can be sourced from a separate
+	// file.
+	var accounts account.Accounts
+	if c.AccountFile != "" {
+		accounts, err = account.Load(c.AccountFile)
+		if err != nil {
+			return err
+		}
+	}
+
 	// lint the pipeline and return an error if any
 	// linting rules are broken
 	lint := linter.New()
+	lint.Pools = pools
+	lint.Accounts = accounts
+	lint.Userdata = c.Settings.Userdata
+	lint.DefaultCredentials = c.Settings.DefaultCredentials
 	err = lint.Lint(resource, c.Repo)
 	if err != nil {
 		return err
@@ -91,9 +127,11 @@ func (c *compileCommand) run(*kingpin.ParseContext) error {
 
 	// compile the pipeline to an intermediate representation.
 	comp := &compiler.Compiler{
-		Environ:    provider.Static(c.Environ),
-		Settings:   c.Settings,
-		Secret:     secret.StaticVars(c.Secrets),
+		Environ:  provider.Static(c.Environ),
+		Settings: c.Settings,
+		Secret:   secret.StaticVars(c.Secrets),
+		Pools:    pools,
+		Accounts: accounts,
 	}
 
 	args := runtime.CompilerArgs{
@@ -105,7 +143,10 @@ func (c *compileCommand) run(*kingpin.ParseContext) error {
 		Stage:    c.Stage,
 		System:   c.System,
 	}
-	spec := comp.Compile(nocontext, args)
+	spec := comp.Compile(nocontext, args).(*engine.Spec)
+	if spec.Error != "" {
+		return errors.New(spec.Error)
+	}
 
 	// encode the pipeline in json format and print to the
 	// console for inspection.
@@ -133,6 +174,19 @@ func registerCompile(app *kingpin.Application) {
 	cmd.Flag("environ", "environment variables").
 		StringMapVar(&c.Environ)
 
+	cmd.Flag("pool-file", "named instance pools file").
+		StringVar(&c.PoolFile)
+
+	cmd.Flag("account-file", "named accounts file").
+		StringVar(&c.AccountFile)
+
+	cmd.Flag("default-credentials", "allow pipelines without account credentials to use the default aws credentials").
+		BoolVar(&c.Settings.DefaultCredentials)
+
+	cmd.Flag("strict", "report unknown yaml fields, use --no-strict to ignore").
+		Default("true").
+		BoolVar(&c.Strict)
+
 	// shared pipeline flags
 	c.Flags = internal.ParseFlags(cmd)
 }
@@ -5,81 +5,144 @@
 package daemon
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
+	"time"
 
+	"github.com/drone-runners/drone-runner-aws/internal/account"
+	"github.com/drone-runners/drone-runner-aws/internal/pool"
+	"github.com/drone-runners/drone-runner-aws/internal/userdata"
+
+	"github.com/buildkite/yaml"
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
 )
 
-// Config stores the system configuration.
+// Config stores the system configuration. The configuration
+// is sourced from the optional configuration file and the
+// environment, where the environment takes precedence.
 type Config struct {
-	Debug bool `envconfig:"DRONE_DEBUG"`
-	Trace bool `envconfig:"DRONE_TRACE"`
+	Debug bool `envconfig:"DRONE_DEBUG" yaml:"debug"`
+	Trace bool `envconfig:"DRONE_TRACE" yaml:"trace"`
 
 	Client struct {
-		Address    string `ignored:"true"`
-		Proto      string `envconfig:"DRONE_RPC_PROTO"  default:"http"`
-		Host       string `envconfig:"DRONE_RPC_HOST"   required:"true"`
-		Secret     string `envconfig:"DRONE_RPC_SECRET" required:"true"`
-		SkipVerify bool   `envconfig:"DRONE_RPC_SKIP_VERIFY"`
-		Dump       bool   `envconfig:"DRONE_RPC_DUMP_HTTP"`
-		DumpBody   bool   `envconfig:"DRONE_RPC_DUMP_HTTP_BODY"`
-	}
+		Address    string `ignored:"true" yaml:"-"`
+		Proto      string `envconfig:"DRONE_RPC_PROTO"  default:"http" yaml:"proto"`
+		Host       string `envconfig:"DRONE_RPC_HOST"   yaml:"host"`
+		Secret     string `envconfig:"DRONE_RPC_SECRET" yaml:"secret"`
+		SkipVerify bool   `envconfig:"DRONE_RPC_SKIP_VERIFY" yaml:"skip_verify"`
+		Dump       bool   `envconfig:"DRONE_RPC_DUMP_HTTP" yaml:"dump_http"`
+		DumpBody   bool   `envconfig:"DRONE_RPC_DUMP_HTTP_BODY" yaml:"dump_http_body"`
+	} `yaml:"client"`
 
 	Dashboard struct {
-		Disabled bool   `envconfig:"DRONE_UI_DISABLE"`
-		Username string `envconfig:"DRONE_UI_USERNAME"`
-		Password string `envconfig:"DRONE_UI_PASSWORD"`
-		Realm    string `envconfig:"DRONE_UI_REALM" default:"MyRealm"`
-	}
+		Disabled bool   `envconfig:"DRONE_UI_DISABLE" yaml:"disabled"`
+		Username string `envconfig:"DRONE_UI_USERNAME" yaml:"username"`
+		Password string `envconfig:"DRONE_UI_PASSWORD" yaml:"password"`
+		Realm    string `envconfig:"DRONE_UI_REALM" default:"MyRealm" yaml:"realm"`
+	} `yaml:"dashboard"`
 
 	Server struct {
-		Port  string `envconfig:"DRONE_HTTP_BIND" default:":3000"`
-		Proto string `envconfig:"DRONE_HTTP_PROTO"`
-		Host  string `envconfig:"DRONE_HTTP_HOST"`
-		Acme  bool   `envconfig:"DRONE_HTTP_ACME"`
-	}
+		Port  string `envconfig:"DRONE_HTTP_BIND" default:":3000" yaml:"port"`
+		Proto string `envconfig:"DRONE_HTTP_PROTO" yaml:"proto"`
+		Host  string `envconfig:"DRONE_HTTP_HOST" yaml:"host"`
+		Acme  bool   `envconfig:"DRONE_HTTP_ACME" yaml:"acme"`
+	} `yaml:"server"`
 
 	Runner struct {
-		Name     string            `envconfig:"DRONE_RUNNER_NAME"`
-		Capacity int               `envconfig:"DRONE_RUNNER_CAPACITY" default:"2"`
-		Procs    int64             `envconfig:"DRONE_RUNNER_MAX_PROCS"`
-		Environ  map[string]string `envconfig:"DRONE_RUNNER_ENVIRON"`
-		EnvFile  string            `envconfig:"DRONE_RUNNER_ENV_FILE"`
-		Secrets  map[string]string `envconfig:"DRONE_RUNNER_SECRETS"`
-		Labels   map[string]string `envconfig:"DRONE_RUNNER_LABELS"`
-	}
+		Name     string            `envconfig:"DRONE_RUNNER_NAME" yaml:"name"`
+		Capacity int               `envconfig:"DRONE_RUNNER_CAPACITY" default:"2" yaml:"capacity"`
+		Procs    int64             `envconfig:"DRONE_RUNNER_MAX_PROCS" yaml:"max_procs"`
+		Environ  map[string]string `envconfig:"DRONE_RUNNER_ENVIRON" yaml:"environ"`
+		EnvFile  string            `envconfig:"DRONE_RUNNER_ENV_FILE" yaml:"env_file"`
+		Secrets  map[string]string `envconfig:"DRONE_RUNNER_SECRETS" yaml:"secrets"`
+		Labels   map[string]string `envconfig:"DRONE_RUNNER_LABELS" yaml:"labels"`
+	} `yaml:"runner"`
 
 	Limit struct {
-		Repos   []string `envconfig:"DRONE_LIMIT_REPOS"`
-		Events  []string `envconfig:"DRONE_LIMIT_EVENTS"`
-		Trusted bool     `envconfig:"DRONE_LIMIT_TRUSTED"`
-	}
+		Repos   []string `envconfig:"DRONE_LIMIT_REPOS" yaml:"repos"`
+		Events  []string `envconfig:"DRONE_LIMIT_EVENTS" yaml:"events"`
+		Trusted bool     `envconfig:"DRONE_LIMIT_TRUSTED" yaml:"trusted"`
+	} `yaml:"limit"`
+
+	Policy struct {
+		IAMProfiles      []string `envconfig:"DRONE_POLICY_IAM_PROFILES" yaml:"iam_profiles"`
+		SecurityGroups   []string `envconfig:"DRONE_POLICY_SECURITY_GROUPS" yaml:"security_groups"`
+		Subnets          []string `envconfig:"DRONE_POLICY_SUBNETS" yaml:"subnets"`
+		InstanceTypes    []string `envconfig:"DRONE_POLICY_INSTANCE_TYPES" yaml:"instance_types"`
+		CacheBuckets     []string `envconfig:"DRONE_POLICY_CACHE_BUCKETS" yaml:"cache_buckets"`
+		CacheRegions     []string `envconfig:"DRONE_POLICY_CACHE_REGIONS" yaml:"cache_regions"`
+		RequirePrivateIP bool     `envconfig:"DRONE_POLICY_REQUIRE_PRIVATE_IP" yaml:"require_private_ip"`
+	} `yaml:"policy"`
 
 	Settings struct {
-		// TODO replace or remove custom settings
-		Param1 string `envconfig:"DRONE_PARAM1"`
-		Param2 string `envconfig:"DRONE_PARAM2"`
-	}
+		Userdata     string `envconfig:"DRONE_SETTINGS_USERDATA" yaml:"userdata"`
+		UserdataFile string `envconfig:"DRONE_SETTINGS_USERDATA_FILE" yaml:"userdata_file"`
+		StrictYAML   bool   `envconfig:"DRONE_SETTINGS_STRICT_YAML" default:"true" yaml:"strict_yaml"`
+
+		// DefaultCredentials allows pipelines without account
+		// credentials to use the credentials of the runner.
+		DefaultCredentials bool `envconfig:"DRONE_SETTINGS_DEFAULT_CREDENTIALS" yaml:"default_credentials"`
+	} `yaml:"settings"`
+
+	Pool struct {
+		File  string     `envconfig:"DRONE_POOL_FILE" yaml:"file"`
+		Pools pool.Pools `ignored:"true" yaml:"-"`
+	} `yaml:"pool"`
+
+	Account struct {
+		File     string           `envconfig:"DRONE_ACCOUNT_FILE" yaml:"file"`
+		Accounts account.Accounts `ignored:"true" yaml:"-"`
+	} `yaml:"account"`
+
+	Reload struct {
+		Interval time.Duration `envconfig:"DRONE_RELOAD_INTERVAL" default:"10s" yaml:"interval"`
+	} `yaml:"reload"`
+
+	Metrics struct {
+		Disabled bool               `envconfig:"DRONE_METRICS_DISABLED" yaml:"disabled"`
+		Prices   map[string]float64 `envconfig:"DRONE_METRICS_PRICES" yaml:"prices"`
+		Public   bool               `envconfig:"DRONE_METRICS_PUBLIC" yaml:"public"`
+	} `yaml:"metrics"`
 
 	Environ struct {
-		Endpoint   string `envconfig:"DRONE_ENV_PLUGIN_ENDPOINT"`
-		Token      string `envconfig:"DRONE_ENV_PLUGIN_TOKEN"`
-		SkipVerify bool   `envconfig:"DRONE_ENV_PLUGIN_SKIP_VERIFY"`
-	}
+		Endpoint   string `envconfig:"DRONE_ENV_PLUGIN_ENDPOINT" yaml:"endpoint"`
+		Token      string `envconfig:"DRONE_ENV_PLUGIN_TOKEN" yaml:"token"`
+		SkipVerify bool   `envconfig:"DRONE_ENV_PLUGIN_SKIP_VERIFY" yaml:"skip_verify"`
+	} `yaml:"environ"`
 
 	Secret struct {
-		Endpoint   string `envconfig:"DRONE_SECRET_PLUGIN_ENDPOINT"`
-		Token      string `envconfig:"DRONE_SECRET_PLUGIN_TOKEN"`
-		SkipVerify bool   `envconfig:"DRONE_SECRET_PLUGIN_SKIP_VERIFY"`
-	}
+		Endpoint   string `envconfig:"DRONE_SECRET_PLUGIN_ENDPOINT" yaml:"endpoint"`
+		Token      string `envconfig:"DRONE_SECRET_PLUGIN_TOKEN" yaml:"token"`
+		SkipVerify bool   `envconfig:"DRONE_SECRET_PLUGIN_SKIP_VERIFY" yaml:"skip_verify"`
+	} `yaml:"secret"`
+
+	AWSSecret struct {
+		Region   string        `envconfig:"DRONE_SECRET_AWS_REGION" default:"us-east-1" yaml:"region"`
+		Endpoint string        `envconfig:"DRONE_SECRET_AWS_ENDPOINT" yaml:"endpoint"`
+		CacheTTL time.Duration `envconfig:"DRONE_SECRET_AWS_CACHE_TTL" default:"5m" yaml:"cache_ttl"`
+		Allow    []string      `envconfig:"DRONE_SECRET_AWS_ALLOW" yaml:"allow"`
+	} `yaml:"aws_secret"`
 
 	Registry struct {
-		Endpoint   string `envconfig:"DRONE_REGISTRY_PLUGIN_ENDPOINT"`
-		Token      string `envconfig:"DRONE_REGISTRY_PLUGIN_TOKEN"`
-		SkipVerify bool   `envconfig:"DRONE_REGISTRY_PLUGIN_SKIP_VERIFY"`
-	}
+		Endpoint   string `envconfig:"DRONE_REGISTRY_PLUGIN_ENDPOINT" yaml:"endpoint"`
+		Token      string `envconfig:"DRONE_REGISTRY_PLUGIN_TOKEN" yaml:"token"`
+		SkipVerify bool   `envconfig:"DRONE_REGISTRY_PLUGIN_SKIP_VERIFY" yaml:"skip_verify"`
+	} `yaml:"registry"`
+
+	Cache struct {
+		Endpoint string `envconfig:"DRONE_CACHE_ENDPOINT" yaml:"endpoint"`
+	} `yaml:"cache"`
+
+	Artifacts struct {
+		Bucket   string `envconfig:"DRONE_ARTIFACTS_BUCKET" yaml:"bucket"`
+		Region   string `envconfig:"DRONE_ARTIFACTS_REGION" default:"us-east-1" yaml:"region"`
+		Endpoint string `envconfig:"DRONE_ARTIFACTS_ENDPOINT" yaml:"endpoint"`
+	} `yaml:"artifacts"`
 }
 
 // legacy environment variables. the key is the legacy
@@ -88,7 +151,20 @@ var legacy = map[string]string{
 	// "DRONE_VARIABLE_OLD": "DRONE_VARIABLE_NEW"
 }
 
-func fromEnviron() (Config, error) {
+// configFile defines the configuration file, which maps onto
+// the configuration and extends it with the named pools and
+// accounts.
+type configFile struct {
+	Config   `yaml:",inline"`
+	Pools    []*pool.Pool       `yaml:"pools"`
+	Accounts []*account.Account `yaml:"accounts"`
+}
+
+// load loads the configuration from the optional yaml or json
+// configuration file and the environment. The environment
+// takes precedence over the configuration file, which takes
+// precedence over the default values.
+func load(path string) (Config, error) {
 	// loop through legacy environment variable and, if set
 	// rewrite to the new variable name.
 	for k, v := range legacy {
@@ -97,11 +173,38 @@ func fromEnviron() (Config, error) {
 		}
 	}
 
-	var config Config
+	var file configFile
+	var keys map[string]bool
+	if path != "" {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return file.Config, err
+		}
+		if err := yaml.UnmarshalStrict(raw, &file); err != nil {
+			return file.Config, fmt.Errorf("%s: %s", path, err)
+		}
+		keys, err = fileKeys(raw)
+		if err != nil {
+			return file.Config, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	config := file.Config
 	err := envconfig.Process("", &config)
 	if err != nil {
 		return config, err
 	}
+	// envconfig applies the default values to the variables
+	// that are not set, so the values defined in the file are
+	// restored.
+	restore(reflect.ValueOf(&config).Elem(), reflect.ValueOf(&file.Config).Elem(), "", keys)
+
+	if config.Client.Host == "" {
+		return config, errors.New("required key DRONE_RPC_HOST missing value")
+	}
+	if config.Client.Secret == "" {
+		return config, errors.New("required key DRONE_RPC_SECRET missing value")
+	}
 	if config.Runner.Environ == nil {
 		config.Runner.Environ = map[string]string{}
 	}
@@ -130,5 +233,120 @@ func fromEnviron() (Config, error) {
 		}
 	}
 
+	// the default custom userdata can be sourced from a
+	// separate file.
+	if file := config.Settings.UserdataFile; file != "" {
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+		config.Settings.Userdata = string(raw)
+	}
+
+	// the default userdata is validated when the configuration
+	// is loaded, instead of when the first instance is created.
+	if custom := config.Settings.Userdata; custom != "" {
+		if err := userdata.Validate(custom); err != nil {
+			return config, fmt.Errorf("invalid default userdata: %s", err)
+		}
+	}
+
+	// the named instance pools are sourced from a separate
+	// yaml file.
+	if file := config.Pool.File; file != "" {
+		pools, err := pool.Load(file)
+		if err != nil {
+			return config, err
+		}
+		config.Pool.Pools = pools
+	}
+
+	// the named accounts are sourced from a separate yaml
+	// file.
+	if file := config.Account.File; file != "" {
+		accounts, err := account.Load(file)
+		if err != nil {
+			return config, err
+		}
+		config.Account.Accounts = accounts
+	}
+
+	// the named pools and accounts can be defined in the
+	// configuration file instead of a separate file.
+	if len(file.Pools) != 0 {
+		if config.Pool.File != "" {
+			return config, errors.New("pools cannot be defined in both the configuration file and the pool file")
+		}
+		config.Pool.Pools, err = pool.New(file.Pools)
+		if err != nil {
+			return config, err
+		}
+	}
+	if len(file.Accounts) != 0 {
+		if config.Account.File != "" {
+			return config, errors.New("accounts cannot be defined in both the configuration file and the account file")
+		}
+		config.Account.Accounts, err = account.New(file.Accounts)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	// the pools can reference the named accounts, which
+	// must be defined.
+	for _, p := range config.Pool.Pools {
+		if name := p.Account.Name; name != "" {
+			if _, ok := config.Account.Accounts.Lookup(name); !ok {
+				return config, fmt.Errorf("pool %s references unknown account %s", p.Name, name)
+			}
+		}
+	}
+
 	return config, nil
 }
+
+// helper function returns the keys defined in the yaml
+// document, including the nested keys of each section (for
+// example runner.capacity).
+func fileKeys(data []byte) (map[string]bool, error) {
+	var doc map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	keys := map[string]bool{}
+	walkKeys(keys, "", doc)
+	return keys, nil
+}
+
+// helper function adds the keys of the section, and of the
+// nested sections, to the key set.
+func walkKeys(keys map[string]bool, prefix string, section map[interface{}]interface{}) {
+	for k, v := range section {
+		key := prefix + fmt.Sprint(k)
+		keys[key] = true
+		if nested, ok := v.(map[interface{}]interface{}); ok {
+			walkKeys(keys, key+".", nested)
+		}
+	}
+}
+
+// helper function restores the configuration values defined
+// in the file, unless the environment variable is set.
+func restore(dst, src reflect.Value, prefix string, keys map[string]bool) {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" || !keys[prefix+name] {
+			continue
+		}
+		env := field.Tag.Get("envconfig")
+		if env == "" && field.Type.Kind() == reflect.Struct {
+			restore(dst.Field(i), src.Field(i), prefix+name+".", keys)
+			continue
+		}
+		if _, ok := os.LookupEnv(env); !ok {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+client:
+  host: drone.company.com
+  secret: correct-horse-battery-staple
+runner:
+  capacity: 5
+settings:
+  strict_yaml: false
+reload:
+  interval: 1m
+`
+
+// This test verifies the values defined in the configuration
+// file are used when the environment variables are not set,
+// and are not overridden by the default values.
+func TestLoad_File(t *testing.T) {
+	path, cleanup := testFile(t, testConfig)
+	defer cleanup()
+
+	config, err := load(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := config.Client.Host, "drone.company.com"; got != want {
+		t.Errorf("Want host %s, got %s", want, got)
+	}
+	if got, want := config.Runner.Capacity, 5; got != want {
+		t.Errorf("Want capacity %d, got %d", want, got)
+	}
+	if config.Settings.StrictYAML {
+		t.Errorf("Want strict yaml disabled by the file, overriding the default")
+	}
+	if got, want := config.Reload.Interval, time.Minute; got != want {
+		t.Errorf("Want reload interval %s, got %s", want, got)
+	}
+	// values not defined in the file use the default values.
+	if got, want := config.Server.Port, ":3000"; got != want {
+		t.Errorf("Want default port %s, got %s", want, got)
+	}
+}
+
+// This test verifies the environment variables take precedence
+// over the values defined in the configuration file.
+func TestLoad_Environ(t *testing.T) {
+	path, cleanup := testFile(t, testConfig)
+	defer cleanup()
+
+	os.Setenv("DRONE_RUNNER_CAPACITY", "10")
+	os.Setenv("DRONE_SETTINGS_STRICT_YAML", "true")
+	defer func() {
+		os.Unsetenv("DRONE_RUNNER_CAPACITY")
+		os.Unsetenv("DRONE_SETTINGS_STRICT_YAML")
+	}()
+
+	config, err := load(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := config.Runner.Capacity, 10; got != want {
+		t.Errorf("Want capacity %d from the environment, got %d", want, got)
+	}
+	if !config.Settings.StrictYAML {
+		t.Errorf("Want strict yaml enabled by the environment")
+	}
+	if got, want := config.Reload.Interval, time.Minute; got != want {
+		t.Errorf("Want reload interval %s from the file, got %s", want, got)
+	}
+}
+
+func TestFileKeys(t *testing.T) {
+	keys, err := fileKeys([]byte(`
+runner:
+  capacity: 5
+  environ:
+    GOOS: linux
+metrics:
+  auth:
+    username: octocat
+`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := map[string]bool{
+		"runner":                true,
+		"runner.capacity":       true,
+		"runner.environ":        true,
+		"runner.environ.GOOS":   true,
+		"metrics":               true,
+		"metrics.auth":          true,
+		"metrics.auth.username": true,
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("Want keys %v, got %v", want, keys)
+	}
+}
+
+// helper function writes the configuration file to a temporary
+// directory, and returns the file path and a function that
+// removes the directory.
+func testFile(t *testing.T, data string) (string, func()) {
+	dir, err := ioutil.TempDir("", "drone-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, func() {
+		os.RemoveAll(dir)
+	}
+}
@@ -6,13 +6,19 @@ package daemon
 
 import (
 	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
 	"time"
 
 	"github.com/drone-runners/drone-runner-aws/engine"
 	"github.com/drone-runners/drone-runner-aws/engine/compiler"
 	"github.com/drone-runners/drone-runner-aws/engine/linter"
 	"github.com/drone-runners/drone-runner-aws/engine/resource"
-	"github.com/drone-runners/drone-runner-aws/internal/match"
+	"github.com/drone-runners/drone-runner-aws/internal/awssecret"
+	"github.com/drone-runners/drone-runner-aws/internal/metrics"
+	"github.com/drone-runners/drone-runner-aws/internal/platform"
 
 	"github.com/drone/runner-go/client"
 	"github.com/drone/runner-go/environ/provider"
@@ -23,6 +29,7 @@ import (
 	"github.com/drone/runner-go/pipeline/reporter/remote"
 	"github.com/drone/runner-go/pipeline/runtime"
 	"github.com/drone/runner-go/poller"
+	"github.com/drone/runner-go/registry"
 	"github.com/drone/runner-go/secret"
 	"github.com/drone/runner-go/server"
 	"github.com/drone/signal"
@@ -38,14 +45,16 @@ var nocontext = context.Background()
 
 type daemonCommand struct {
 	envfile string
+	config  string
 }
 
 func (c *daemonCommand) run(*kingpin.ParseContext) error {
 	// load environment variables from file.
 	godotenv.Load(c.envfile)
 
-	// load the configuration from the environment
-	config, err := fromEnviron()
+	// load the configuration from the configuration file
+	// and the environment.
+	config, err := load(configPath(c.config))
 	if err != nil {
 		return err
 	}
@@ -79,7 +88,23 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		),
 	)
 
-	opts := engine.Opts{}
+	// the instance lifecycle and step execution metrics are
+	// served at the /metrics endpoint, which requires the
+	// dashboard credentials unless public.
+	var metric *metrics.Prometheus
+	if !config.Metrics.Disabled {
+		metric = metrics.New(config.Metrics.Prices)
+	}
+
+	opts := engine.Opts{
+		CacheEndpoint:     config.Cache.Endpoint,
+		ArtifactsBucket:   config.Artifacts.Bucket,
+		ArtifactsRegion:   config.Artifacts.Region,
+		ArtifactsEndpoint: config.Artifacts.Endpoint,
+	}
+	if metric != nil {
+		opts.Metrics = metric
+	}
 	engine, err := engine.New(opts)
 	if err != nil {
 		logrus.WithError(err).
@@ -105,25 +130,45 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 	hook := loghistory.New()
 	logrus.AddHook(hook)
 
+	// the linter verifies the AMI is tagged as docker-capable
+	// using the default credentials of the runner.
+	lint := linter.New()
+	lint.ImageTags = func(region, ami string) (map[string]string, error) {
+		return platform.ImageTags(ctx, platform.Credentials{Region: region}, ami)
+	}
+
+	// the sensitive instance settings are restricted to
+	// trusted repositories, unless allowed by the runner.
+	lint.Allow = linter.Allowlist{
+		IAMProfiles:    config.Policy.IAMProfiles,
+		SecurityGroups: config.Policy.SecurityGroups,
+		Subnets:        config.Policy.Subnets,
+		InstanceTypes:  config.Policy.InstanceTypes,
+		CacheBuckets:   config.Policy.CacheBuckets,
+		CacheRegions:   config.Policy.CacheRegions,
+	}
+	lint.RequirePrivateIP = config.Policy.RequirePrivateIP
+	lint.Userdata = config.Settings.Userdata
+	lint.DefaultCredentials = config.Settings.DefaultCredentials
+
+	// the limits, the runner secrets and environment, and
+	// the named pools and accounts are reloaded on SIGHUP, or
+	// when the configuration files are modified.
+	reloader := newReloader(configPath(c.config), config)
+
 	runner := &runtime.Runner{
 		Client:   cli,
 		Machine:  config.Runner.Name,
 		Reporter: tracer,
-		Lookup:   resource.Lookup,
-		Lint:     linter.New().Lint,
-		Match: match.Func(
-			config.Limit.Repos,
-			config.Limit.Events,
-			config.Limit.Trusted,
-		),
-		Compiler: &compiler.Compiler{
+		Lint:     reloader.lint(lint),
+		Match:    reloader.match,
+		Compiler: reloader.compiler(&compiler.Compiler{
 			Settings: compiler.Settings{
-				// TODO replace or remove
-				Param1: config.Settings.Param1,
-				Param2: config.Settings.Param2,
+				Userdata:           config.Settings.Userdata,
+				DefaultCredentials: config.Settings.DefaultCredentials,
 			},
 			Environ: provider.Combine(
-				provider.Static(config.Runner.Environ),
+				reloader.environ(),
 				provider.External(
 					config.Environ.Endpoint,
 					config.Environ.Token,
@@ -131,16 +176,27 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 				),
 			),
 			Secret: secret.Combine(
-				secret.StaticVars(
-					config.Runner.Secrets,
-				),
+				reloader.secrets(),
 				secret.External(
 					config.Secret.Endpoint,
 					config.Secret.Token,
 					config.Secret.SkipVerify,
 				),
+				awssecret.New(awssecret.Config{
+					Region:   config.AWSSecret.Region,
+					Endpoint: config.AWSSecret.Endpoint,
+					TTL:      config.AWSSecret.CacheTTL,
+					Allow:    config.AWSSecret.Allow,
+				}),
 			),
-		},
+			Registry: registry.Combine(
+				registry.External(
+					config.Registry.Endpoint,
+					config.Registry.Token,
+					config.Registry.SkipVerify,
+				),
+			),
+		}),
 		Exec: runtime.NewExecer(
 			tracer,
 			remote,
@@ -151,21 +207,29 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 
 	poller := &poller.Poller{
 		Client:   cli,
-		Dispatch: runner.Run,
+		Dispatch: dispatcher(runner, config.Settings.StrictYAML),
 		Filter: &client.Filter{
 			Kind: resource.Kind,
 			Type: resource.Type,
 		},
 	}
 
+	var handler http.Handler = router.New(tracer, hook, router.Config{
+		Username: config.Dashboard.Username,
+		Password: config.Dashboard.Password,
+		Realm:    config.Dashboard.Realm,
+	})
+	if h := metricsHandler(config, metric); h != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", h)
+		mux.Handle("/", handler)
+		handler = mux
+	}
+
 	var g errgroup.Group
 	server := server.Server{
-		Addr: config.Server.Port,
-		Handler: router.New(tracer, hook, router.Config{
-			Username: config.Dashboard.Username,
-			Password: config.Dashboard.Password,
-			Realm:    config.Dashboard.Realm,
-		}),
+		Addr:    config.Server.Port,
+		Handler: handler,
 	}
 
 	logrus.WithField("addr", config.Server.Port).
@@ -175,6 +239,11 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		return server.ListenAndServe(ctx)
 	})
 
+	g.Go(func() error {
+		reloader.watch(ctx, config.Reload.Interval)
+		return nil
+	})
+
 	// Ping the server and block until a successful connection
 	// to the server has been established.
 	for {
@@ -216,6 +285,44 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 	return err
 }
 
+// helper function returns the handler that serves the metrics
+// using the dashboard basic authentication, or without
+// authentication if the metrics are public. The metrics are
+// not served if neither is configured.
+func metricsHandler(config Config, metric *metrics.Prometheus) http.Handler {
+	switch {
+	case metric == nil:
+		return nil
+	case config.Metrics.Public:
+		return metric.Handler()
+	case config.Dashboard.Password != "":
+		return basicAuth(metric.Handler(),
+			config.Dashboard.Realm,
+			config.Dashboard.Username,
+			config.Dashboard.Password,
+		)
+	default:
+		logrus.Warnln("metrics endpoint disabled, requires the dashboard password or DRONE_METRICS_PUBLIC")
+		return nil
+	}
+}
+
+// helper function returns a handler that requires the basic
+// authentication credentials.
+func basicAuth(h http.Handler, realm, username, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
 // helper function configures the global logger from
 // the loaded configuration.
 func setupLogger(config Config) {
@@ -243,4 +350,18 @@ func Register(app *kingpin.Application) {
 	cmd.Arg("envfile", "load the environment variable file").
 		Default("").
 		StringVar(&c.envfile)
+
+	cmd.Flag("config", "load the yaml or json configuration file").
+		StringVar(&c.config)
+}
+
+// helper function returns the path of the configuration file,
+// which defaults to the DRONE_RUNNER_CONFIG_FILE variable. The
+// variable is read after the environment variable file is
+// loaded, so it can be defined in the file.
+func configPath(path string) string {
+	if path == "" {
+		path = os.Getenv("DRONE_RUNNER_CONFIG_FILE")
+	}
+	return path
 }
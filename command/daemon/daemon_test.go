@@ -0,0 +1,60 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/internal/metrics"
+)
+
+// This test verifies the metrics require the dashboard
+// credentials, unless the metrics are public.
+func TestMetricsHandler(t *testing.T) {
+	metric := metrics.New(nil)
+
+	var config Config
+	if h := metricsHandler(config, metric); h != nil {
+		t.Errorf("Want metrics not served without credentials")
+	}
+	if h := metricsHandler(config, nil); h != nil {
+		t.Errorf("Want metrics not served when disabled")
+	}
+
+	config.Dashboard.Username = "admin"
+	config.Dashboard.Password = "correct-horse-battery-staple"
+	config.Dashboard.Realm = "MyRealm"
+	h := metricsHandler(config, metric)
+
+	tests := []struct {
+		username, password string
+		status             int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"admin", "password", http.StatusUnauthorized},
+		{"admin", "correct-horse-battery-staple", http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if test.username != "" {
+			r.SetBasicAuth(test.username, test.password)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got, want := w.Code, test.status; got != want {
+			t.Errorf("Want status %d for user %q, got %d", want, test.username, got)
+		}
+	}
+
+	config.Dashboard.Password = ""
+	config.Metrics.Public = true
+	w := httptest.NewRecorder()
+	metricsHandler(config, metric).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("Want public metrics served with status %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-aws/engine/resource"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/client"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/pipeline/runtime"
+)
+
+// helper function returns a function that runs each stage with
+// a copy of the runner, which looks up the pipeline in strict
+// mode, if enabled, and reports line numbers relative to the
+// configuration file fetched with the stage details.
+func dispatcher(runner *runtime.Runner, strict bool) func(context.Context, *drone.Stage) error {
+	return func(ctx context.Context, stage *drone.Stage) error {
+		client := &configClient{Client: runner.Client}
+		r := *runner
+		r.Client = client
+		r.Lookup = func(name string, m *manifest.Manifest) (manifest.Resource, error) {
+			return resource.Lookup(name, m,
+				resource.WithStrict(strict),
+				resource.WithConfig(client.config),
+			)
+		}
+		return r.Run(ctx, stage)
+	}
+}
+
+// configClient records the configuration file fetched with the
+// stage details.
+type configClient struct {
+	client.Client
+	config string
+}
+
+func (c *configClient) Detail(ctx context.Context, stage *drone.Stage) (*client.Context, error) {
+	data, err := c.Client.Detail(ctx, stage)
+	if err == nil && data.Config != nil {
+		c.config = string(data.Config.Data)
+	}
+	return data, err
+}
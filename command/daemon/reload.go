@@ -0,0 +1,296 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/engine/compiler"
+	"github.com/drone-runners/drone-runner-aws/engine/linter"
+	"github.com/drone-runners/drone-runner-aws/internal/match"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/environ/provider"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/pipeline/runtime"
+	"github.com/drone/runner-go/secret"
+	"github.com/sirupsen/logrus"
+)
+
+// reloader provides the current configuration, and reloads
+// the reloadable sections of the configuration without
+// restarting the daemon. The reloadable sections are the
+// limits, the runner secrets and environment, and the named
+// pools and accounts. The configuration is replaced
+// atomically, and the previous configuration is kept if the
+// new configuration cannot be loaded.
+type reloader struct {
+	path  string
+	mu    sync.Mutex
+	value atomic.Value
+}
+
+func newReloader(path string, config Config) *reloader {
+	r := &reloader{path: path}
+	r.value.Store(&config)
+	return r
+}
+
+// current returns the current configuration.
+func (r *reloader) current() *Config {
+	return r.value.Load().(*Config)
+}
+
+// reload reloads the configuration from the configuration
+// file and the environment.
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := load(r.path)
+	if err != nil {
+		return err
+	}
+	prev := r.current()
+	config := *prev
+	config.Limit = next.Limit
+	config.Runner.Secrets = next.Runner.Secrets
+	config.Runner.Environ = next.Runner.Environ
+	config.Pool = next.Pool
+	config.Account = next.Account
+
+	if s := restartSections(prev, &next); len(s) != 0 {
+		logrus.WithField("sections", strings.Join(s, ", ")).
+			Warnln("configuration changes require a restart and are ignored")
+	}
+	changes := changedSections(prev, &config)
+	r.value.Store(&config)
+	if len(changes) == 0 {
+		logrus.Infoln("configuration reloaded, no changes")
+		return nil
+	}
+	for _, change := range changes {
+		logrus.WithField("section", change).
+			Infoln("configuration reloaded")
+	}
+	return nil
+}
+
+// watch reloads the configuration when the daemon receives
+// the SIGHUP signal, or when the configuration file, the pool
+// file or the account file is modified. The files are polled
+// at the interval, and are not polled if the interval is zero.
+func (r *reloader) watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	mod := r.modified()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logrus.Infoln("received SIGHUP, reloading the configuration")
+		case <-tick:
+			next := r.modified()
+			if next == mod {
+				continue
+			}
+			logrus.Infoln("configuration file changed, reloading the configuration")
+		}
+		if err := r.reload(); err != nil {
+			logrus.WithError(err).
+				Errorln("cannot reload the configuration, keeping the previous configuration")
+		}
+		mod = r.modified()
+	}
+}
+
+// modified returns the modification time and size of the
+// configuration file, and the pool and account files.
+func (r *reloader) modified() string {
+	config := r.current()
+	var out []string
+	for _, path := range []string{r.path, config.Pool.File, config.Account.File} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			out = append(out, path+":missing")
+			continue
+		}
+		out = append(out, fmt.Sprintf("%s:%d:%d", path, info.ModTime().UnixNano(), info.Size()))
+	}
+	return strings.Join(out, ",")
+}
+
+// match returns true if the repository and build match the
+// current limits.
+func (r *reloader) match(repo *drone.Repo, build *drone.Build) bool {
+	limit := r.current().Limit
+	return match.Func(limit.Repos, limit.Events, limit.Trusted)(repo, build)
+}
+
+// lint returns a lint function that lints the pipeline using
+// the current pools and accounts.
+func (r *reloader) lint(lint *linter.Linter) func(manifest.Resource, *drone.Repo) error {
+	return func(pipeline manifest.Resource, repo *drone.Repo) error {
+		config := r.current()
+		l := *lint
+		l.Pools = config.Pool.Pools
+		l.Accounts = config.Account.Accounts
+		return l.Lint(pipeline, repo)
+	}
+}
+
+// compiler returns a compiler that compiles the pipeline using
+// the current pools and accounts.
+func (r *reloader) compiler(comp *compiler.Compiler) runtime.Compiler {
+	return &reloadCompiler{reloader: r, compiler: comp}
+}
+
+type reloadCompiler struct {
+	reloader *reloader
+	compiler *compiler.Compiler
+}
+
+func (c *reloadCompiler) Compile(ctx context.Context, args runtime.CompilerArgs) runtime.Spec {
+	config := c.reloader.current()
+	comp := *c.compiler
+	comp.Pools = config.Pool.Pools
+	comp.Accounts = config.Account.Accounts
+	return comp.Compile(ctx, args)
+}
+
+// environ returns a provider of the current runner environment.
+func (r *reloader) environ() provider.Provider {
+	return &reloadEnviron{reloader: r}
+}
+
+type reloadEnviron struct {
+	reloader *reloader
+}
+
+func (p *reloadEnviron) List(ctx context.Context, in *provider.Request) ([]*provider.Variable, error) {
+	return provider.Static(p.reloader.current().Runner.Environ).List(ctx, in)
+}
+
+// secrets returns a provider of the current runner secrets.
+func (r *reloader) secrets() secret.Provider {
+	return &reloadSecrets{reloader: r}
+}
+
+type reloadSecrets struct {
+	reloader *reloader
+}
+
+func (p *reloadSecrets) Find(ctx context.Context, in *secret.Request) (*drone.Secret, error) {
+	return secret.StaticVars(p.reloader.current().Runner.Secrets).Find(ctx, in)
+}
+
+// helper function returns the reloadable sections that changed
+// and, for named values, the names that were added, removed or
+// updated. Values are not included since they can be secret.
+func changedSections(prev, next *Config) []string {
+	sections := []struct {
+		name       string
+		prev, next interface{}
+	}{
+		{"limit", prev.Limit, next.Limit},
+		{"runner.secrets", prev.Runner.Secrets, next.Runner.Secrets},
+		{"runner.environ", prev.Runner.Environ, next.Runner.Environ},
+		{"pools", prev.Pool.Pools, next.Pool.Pools},
+		{"accounts", prev.Account.Accounts, next.Account.Accounts},
+	}
+	var out []string
+	for _, s := range sections {
+		if reflect.DeepEqual(s.prev, s.next) {
+			continue
+		}
+		if reflect.TypeOf(s.prev).Kind() == reflect.Map {
+			out = append(out, s.name+" "+diffKeys(s.prev, s.next))
+		} else {
+			out = append(out, s.name)
+		}
+	}
+	return out
+}
+
+// helper function returns the keys added, removed and updated
+// between the two maps.
+func diffKeys(prev, next interface{}) string {
+	pv, nv := reflect.ValueOf(prev), reflect.ValueOf(next)
+	var added, removed, updated []string
+	for _, k := range nv.MapKeys() {
+		p := pv.MapIndex(k)
+		switch {
+		case !p.IsValid():
+			added = append(added, k.String())
+		case !reflect.DeepEqual(p.Interface(), nv.MapIndex(k).Interface()):
+			updated = append(updated, k.String())
+		}
+	}
+	for _, k := range pv.MapKeys() {
+		if !nv.MapIndex(k).IsValid() {
+			removed = append(removed, k.String())
+		}
+	}
+	var parts []string
+	for _, p := range []struct {
+		verb string
+		keys []string
+	}{
+		{"added", added},
+		{"removed", removed},
+		{"updated", updated},
+	} {
+		if len(p.keys) != 0 {
+			sort.Strings(p.keys)
+			parts = append(parts, p.verb+" "+strings.Join(p.keys, ", "))
+		}
+	}
+	return "(" + strings.Join(parts, "; ") + ")"
+}
+
+// helper function returns the top-level sections, other than
+// the reloadable sections, that changed. The reloadable
+// sections are zeroed in copies of both configurations, so
+// that they are excluded from the comparison.
+func restartSections(prev, next *Config) []string {
+	a, b := *prev, *next
+	for _, c := range []*Config{&a, &b} {
+		c.Limit = Config{}.Limit
+		c.Runner.Secrets = nil
+		c.Runner.Environ = nil
+		c.Pool = Config{}.Pool
+		c.Account = Config{}.Account
+	}
+	var out []string
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < av.NumField(); i++ {
+		if !reflect.DeepEqual(av.Field(i).Interface(), bv.Field(i).Interface()) {
+			out = append(out, strings.Split(av.Type().Field(i).Tag.Get("yaml"), ",")[0])
+		}
+	}
+	return out
+}
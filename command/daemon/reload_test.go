@@ -0,0 +1,233 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/internal/account"
+	"github.com/drone-runners/drone-runner-aws/internal/pool"
+)
+
+const testReloadConfig = `
+client:
+  host: drone.company.com
+  secret: correct-horse-battery-staple
+runner:
+  capacity: 2
+limit:
+  repos: [ octocat/* ]
+pools:
+- name: linux
+  account:
+    name: prod
+  instance:
+    ami: ami-123
+accounts:
+- name: prod
+  region: us-east-1
+`
+
+const testReloadConfigChanged = `
+client:
+  host: drone.company.com
+  secret: correct-horse-battery-staple
+runner:
+  capacity: 10
+limit:
+  repos: [ spaceghost/* ]
+pools:
+- name: linux
+  account:
+    name: prod
+  instance:
+    ami: ami-456
+- name: windows
+  account:
+    name: dev
+  instance:
+    ami: ami-789
+accounts:
+- name: prod
+  region: us-east-1
+- name: dev
+  region: eu-west-1
+`
+
+// This test verifies the reloadable sections are reloaded, and
+// the sections that require a restart are ignored.
+func TestReload(t *testing.T) {
+	path, cleanup := testFile(t, testReloadConfig)
+	defer cleanup()
+
+	config, err := load(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r := newReloader(path, config)
+
+	if err := ioutil.WriteFile(path, []byte(testReloadConfigChanged), 0600); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := r.reload(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	current := r.current()
+	if got, want := current.Limit.Repos, []string{"spaceghost/*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want limit repos %v, got %v", want, got)
+	}
+	if p, ok := current.Pool.Pools.Lookup("linux"); !ok || p.Instance.AMI != "ami-456" {
+		t.Errorf("Want updated pool linux")
+	}
+	if _, ok := current.Pool.Pools.Lookup("windows"); !ok {
+		t.Errorf("Want added pool windows")
+	}
+	if _, ok := current.Account.Accounts.Lookup("dev"); !ok {
+		t.Errorf("Want added account dev")
+	}
+	if got, want := current.Runner.Capacity, 2; got != want {
+		t.Errorf("Want capacity %d kept until restart, got %d", want, got)
+	}
+}
+
+// This test verifies the previous configuration is kept when
+// the configuration file cannot be loaded.
+func TestReload_Error(t *testing.T) {
+	path, cleanup := testFile(t, testReloadConfig)
+	defer cleanup()
+
+	config, err := load(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r := newReloader(path, config)
+	prev := r.current()
+
+	if err := ioutil.WriteFile(path, []byte("pools: [ { name: linux } ]"), 0600); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := r.reload(); err == nil {
+		t.Errorf("Want error loading an invalid configuration file")
+	}
+	if r.current() != prev {
+		t.Errorf("Want previous configuration kept")
+	}
+	if _, ok := r.current().Pool.Pools.Lookup("linux"); !ok {
+		t.Errorf("Want previous pools kept")
+	}
+}
+
+func TestChangedSections(t *testing.T) {
+	prev := new(Config)
+	prev.Limit.Trusted = true
+	prev.Runner.Secrets = map[string]string{"token": "a", "password": "b"}
+	prev.Pool.Pools = pool.Pools{"linux": {Name: "linux"}}
+
+	next := new(Config)
+	next.Limit.Trusted = true
+	next.Runner.Secrets = map[string]string{"token": "c", "username": "d"}
+	next.Pool.Pools = pool.Pools{"linux": {Name: "linux"}}
+	next.Account.Accounts = account.Accounts{"prod": {Name: "prod"}}
+
+	got := changedSections(prev, next)
+	want := []string{
+		"runner.secrets (added username; removed password; updated token)",
+		"accounts (added prod)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Want changed sections %q, got %q", want, got)
+	}
+	if got := changedSections(prev, prev); len(got) != 0 {
+		t.Errorf("Want no changed sections, got %q", got)
+	}
+}
+
+func TestDiffKeys(t *testing.T) {
+	tests := []struct {
+		prev, next map[string]string
+		want       string
+	}{
+		{
+			prev: map[string]string{"a": "1"},
+			next: map[string]string{"a": "1", "b": "2"},
+			want: "(added b)",
+		},
+		{
+			prev: map[string]string{"a": "1", "b": "2"},
+			next: map[string]string{"a": "2"},
+			want: "(removed b; updated a)",
+		},
+		{
+			prev: nil,
+			next: map[string]string{"b": "2", "a": "1"},
+			want: "(added a, b)",
+		},
+	}
+	for _, test := range tests {
+		if got := diffKeys(test.prev, test.next); got != test.want {
+			t.Errorf("Want diff %s, got %s", test.want, got)
+		}
+	}
+}
+
+// This test verifies the reloadable sections are excluded when
+// reporting the sections that require a restart.
+func TestRestartSections(t *testing.T) {
+	prev := new(Config)
+	prev.Runner.Capacity = 2
+	prev.Limit.Repos = []string{"octocat/*"}
+	prev.Pool.File = "/etc/drone/pools.yml"
+
+	next := new(Config)
+	next.Runner.Capacity = 2
+	next.Runner.Secrets = map[string]string{"token": "a"}
+	next.Account.Accounts = account.Accounts{"prod": {Name: "prod"}}
+
+	if got := restartSections(prev, next); len(got) != 0 {
+		t.Errorf("Want reloadable sections excluded, got %q", got)
+	}
+
+	next.Runner.Capacity = 10
+	next.Server.Port = ":8080"
+	got := restartSections(prev, next)
+	want := []string{"server", "runner"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Want restart sections %q, got %q", want, got)
+	}
+	if prev.Limit.Repos == nil || next.Account.Accounts == nil {
+		t.Errorf("Want configurations not modified")
+	}
+}
+
+func TestModified(t *testing.T) {
+	path, cleanup := testFile(t, testReloadConfig)
+	defer cleanup()
+
+	r := newReloader(path, Config{})
+	before := r.modified()
+	if before == "" {
+		t.Errorf("Want modification details of the configuration file")
+	}
+	if err := ioutil.WriteFile(path, []byte(testReloadConfigChanged), 0600); err != nil {
+		t.Error(err)
+		return
+	}
+	if after := r.modified(); after == before {
+		t.Errorf("Want modification details changed")
+	}
+	os.Remove(path)
+	if got, want := r.modified(), path+":missing"; got != want {
+		t.Errorf("Want modification details %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type validateCommand struct {
+	envfile string
+	config  string
+}
+
+func (c *validateCommand) run(*kingpin.ParseContext) error {
+	// load environment variables from file.
+	godotenv.Load(c.envfile)
+
+	// load the configuration from the configuration file
+	// and the environment, and report errors without
+	// starting the daemon.
+	config, err := load(configPath(c.config))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("configuration is valid (%d pools, %d accounts)\n",
+		len(config.Pool.Pools),
+		len(config.Account.Accounts),
+	)
+	return nil
+}
+
+// RegisterValidate registers the validate command.
+func RegisterValidate(app *kingpin.Application) {
+	c := new(validateCommand)
+
+	cmd := app.Command("validate", "validates the daemon configuration").
+		Action(c.run)
+
+	cmd.Arg("envfile", "load the environment variable file").
+		Default("").
+		StringVar(&c.envfile)
+
+	cmd.Flag("config", "load the yaml or json configuration file").
+		StringVar(&c.config)
+}
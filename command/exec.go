@@ -7,6 +7,7 @@ package command
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -18,6 +19,10 @@ import (
 	"github.com/drone-runners/drone-runner-aws/engine/compiler"
 	"github.com/drone-runners/drone-runner-aws/engine/linter"
 	"github.com/drone-runners/drone-runner-aws/engine/resource"
+	"github.com/drone-runners/drone-runner-aws/internal/account"
+	"github.com/drone-runners/drone-runner-aws/internal/awssecret"
+	"github.com/drone-runners/drone-runner-aws/internal/platform"
+	"github.com/drone-runners/drone-runner-aws/internal/pool"
 
 	"github.com/drone/drone-go/drone"
 	"github.com/drone/envsubst"
@@ -28,6 +33,7 @@ import (
 	"github.com/drone/runner-go/pipeline"
 	"github.com/drone/runner-go/pipeline/runtime"
 	"github.com/drone/runner-go/pipeline/streamer/console"
+	"github.com/drone/runner-go/registry"
 	"github.com/drone/runner-go/secret"
 	"github.com/drone/signal"
 
@@ -39,17 +45,25 @@ import (
 type execCommand struct {
 	*internal.Flags
 
-	Source     *os.File
-	Include    []string
-	Exclude    []string
-	Environ    map[string]string
-	Secrets    map[string]string
-	Settings   compiler.Settings
-	Pretty     bool
-	Procs      int64
-	Debug      bool
-	Trace      bool
-	Dump       bool
+	Source        *os.File
+	Include       []string
+	Exclude       []string
+	Environ       map[string]string
+	Secrets       map[string]string
+	Settings      compiler.Settings
+	UserdataFile  string
+	PoolFile      string
+	AccountFile   string
+	Config        string
+	CacheEndpoint string
+	ArtifactsDir  string
+	AWSSecret     awssecret.Config
+	Strict        bool
+	Pretty        bool
+	Procs         int64
+	Debug         bool
+	Trace         bool
+	Dump          bool
 }
 
 func (c *execCommand) run(*kingpin.ParseContext) error {
@@ -93,15 +107,57 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 	}
 
 	// a configuration can contain multiple pipelines.
-	// get a specific pipeline resource for execution.
-	res, err := resource.Lookup(c.Stage.Name, manifest)
+	// get a specific pipeline resource for execution. strict
+	// parsing reports unknown yaml fields, which are otherwise
+	// ignored.
+	res, err := resource.Lookup(c.Stage.Name, manifest,
+		resource.WithStrict(c.Strict),
+		resource.WithConfig(config),
+	)
 	if err != nil {
 		return err
 	}
 
+	// the named instance pools can be sourced from a
+	// separate file.
+	var pools pool.Pools
+	if c.PoolFile != "" {
+		pools, err = pool.Load(c.PoolFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the named accounts can be sourced from a separate
+	// file.
+	var accounts account.Accounts
+	if c.AccountFile != "" {
+		accounts, err = account.Load(c.AccountFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the default custom userdata can be sourced from a
+	// separate file.
+	if c.UserdataFile != "" {
+		raw, err := ioutil.ReadFile(c.UserdataFile)
+		if err != nil {
+			return err
+		}
+		c.Settings.Userdata = string(raw)
+	}
+
 	// lint the pipeline and return an error if any
 	// linting rules are broken
 	lint := linter.New()
+	lint.ImageTags = func(region, ami string) (map[string]string, error) {
+		return platform.ImageTags(nocontext, platform.Credentials{Region: region}, ami)
+	}
+	lint.Pools = pools
+	lint.Accounts = accounts
+	lint.Userdata = c.Settings.Userdata
+	lint.DefaultCredentials = c.Settings.DefaultCredentials
 	err = lint.Lint(res, c.Repo)
 	if err != nil {
 		return err
@@ -109,12 +165,19 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 
 	// compile the pipeline to an intermediate representation.
 	comp := &compiler.Compiler{
-		Environ:    provider.Static(c.Environ),
-		Settings:   c.Settings,
-		Secret:     secret.StaticVars(c.Secrets),
+		Environ:  provider.Static(c.Environ),
+		Settings: c.Settings,
+		Pools:    pools,
+		Accounts: accounts,
+		Secret: secret.Combine(
+			secret.StaticVars(c.Secrets),
+			awssecret.New(c.AWSSecret),
+		),
+		Registry: registry.Combine(
+			registry.File(c.Config),
+		),
 	}
 
-	
 	args := runtime.CompilerArgs{
 		Pipeline: res,
 		Manifest: manifest,
@@ -125,6 +188,9 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 		System:   c.System,
 	}
 	spec := comp.Compile(nocontext, args).(*engine.Spec)
+	if spec.Error != "" {
+		return errors.New(spec.Error)
+	}
 
 	// include only steps that are in the include list,
 	// if the list in non-empty.
@@ -207,7 +273,10 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 		),
 	)
 
-	engine, err := engine.New(engine.Opts{})
+	engine, err := engine.New(engine.Opts{
+		CacheEndpoint: c.CacheEndpoint,
+		ArtifactsDir:  c.ArtifactsDir,
+	})
 	if err != nil {
 		return err
 	}
@@ -221,6 +290,7 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 
 	if c.Dump {
 		dump(state)
+		dumpRuntime(spec)
 	}
 	if err != nil {
 		return err
@@ -238,6 +308,26 @@ func dump(v interface{}) {
 	enc.Encode(v)
 }
 
+// helper function dumps the instance runtime details and the
+// instance environment variables injected into each step. The
+// full spec is not dumped, because the spec contains the
+// account credentials, secrets and files with credentials.
+func dumpRuntime(spec *engine.Spec) {
+	if spec.Runtime == nil {
+		return
+	}
+	environ := map[string]string{}
+	if len(spec.Steps) != 0 {
+		for _, k := range engine.RuntimeEnviron {
+			environ[k] = spec.Steps[0].Envs[k]
+		}
+	}
+	dump(struct {
+		Runtime *engine.Runtime   `json:"runtime"`
+		Environ map[string]string `json:"environment"`
+	}{spec.Runtime, environ})
+}
+
 func registerExec(app *kingpin.Application) {
 	c := new(execCommand)
 	c.Environ = map[string]string{}
@@ -268,9 +358,13 @@ func registerExec(app *kingpin.Application) {
 	cmd.Flag("trace", "enable trace logging").
 		BoolVar(&c.Trace)
 
-	cmd.Flag("dump", "dump the pipeline state to stdout").
+	cmd.Flag("dump", "dump the pipeline state and instance runtime to stdout").
 		BoolVar(&c.Dump)
 
+	cmd.Flag("strict", "report unknown yaml fields, use --no-strict to ignore").
+		Default("true").
+		BoolVar(&c.Strict)
+
 	cmd.Flag("pretty", "pretty print the output").
 		Default(
 			fmt.Sprint(
@@ -280,15 +374,33 @@ func registerExec(app *kingpin.Application) {
 			),
 		).BoolVar(&c.Pretty)
 
-	//
-	// TODO replace or remove custom settings
-	//
- 
-	cmd.Flag("param1", "custom parameter 1").
-		StringVar(&c.Settings.Param1)
+	cmd.Flag("userdata-file", "default custom userdata file").
+		StringVar(&c.UserdataFile)
+
+	cmd.Flag("pool-file", "named instance pools file").
+		StringVar(&c.PoolFile)
+
+	cmd.Flag("account-file", "named accounts file").
+		StringVar(&c.AccountFile)
+
+	cmd.Flag("default-credentials", "allow pipelines without account credentials to use the default aws credentials").
+		BoolVar(&c.Settings.DefaultCredentials)
+
+	cmd.Flag("docker-config", "path to the docker config file").
+		StringVar(&c.Config)
+
+	cmd.Flag("cache-endpoint", "s3-compatible cache endpoint").
+		StringVar(&c.CacheEndpoint)
+
+	cmd.Flag("artifacts-dir", "directory to which artifacts are copied").
+		StringVar(&c.ArtifactsDir)
+
+	cmd.Flag("aws-secret-region", "region of aws secrets manager and ssm secrets").
+		Default("us-east-1").
+		StringVar(&c.AWSSecret.Region)
 
-	cmd.Flag("param2", "custom parameter 2").
-		StringVar(&c.Settings.Param2)
+	cmd.Flag("aws-secret-allow", "aws secret paths allowed per repository, as repo=prefix").
+		StringsVar(&c.AWSSecret.Allow)
 
 	// shared pipeline flags
 	c.Flags = internal.ParseFlags(cmd)
@@ -0,0 +1,211 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/cache"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/pipeline/runtime"
+)
+
+// duration after which the artifact download links expire.
+var artifactExpiry = time.Hour * 24
+
+// helper function runs the artifacts step, which copies the
+// files matching the artifact paths from the instance to the
+// artifacts directory, or uploads the files to the artifacts
+// bucket. Artifact errors are written to the step log, but do
+// not fail the pipeline.
+func (e *Engine) runArtifacts(ctx context.Context, spec *Spec, step *Step, client transport, output io.Writer) (*runtime.State, error) {
+	state := &runtime.State{
+		ExitCode: 0,
+		Exited:   true,
+	}
+	if e.ArtifactsDir == "" && e.ArtifactsBucket == "" {
+		fmt.Fprintln(output, "artifacts are not configured on this runner, skipping")
+		return state, nil
+	}
+
+	err := e.collectArtifacts(ctx, spec, step, client, output)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			WithField("step", step.Name).
+			Warn("cannot collect artifacts")
+		fmt.Fprintf(output, "artifacts error: %s\n", err)
+	}
+	return state, nil
+}
+
+// helper function lists the files matching the artifact paths
+// and copies each file from the instance.
+func (e *Engine) collectArtifacts(ctx context.Context, spec *Spec, step *Step, client transport, output io.Writer) error {
+	buf := new(bytes.Buffer)
+	code, err := client.Exec(ctx, listScript(spec.Platform.OS, step.WorkingDir, step.Artifacts), buf)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("cannot list artifacts: exit code %d", code)
+	}
+	files := parseList(buf.String())
+	if len(files) == 0 {
+		fmt.Fprintln(output, "no artifacts found")
+		return nil
+	}
+
+	for _, name := range files {
+		src := path.Join(step.WorkingDir, name)
+		if e.ArtifactsDir != "" {
+			n, err := downloadArtifact(ctx, client, src, e.ArtifactsDir, name)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(output, "downloaded %s (%d bytes)\n", name, n)
+		}
+		if e.ArtifactsBucket != "" {
+			n, link, err := e.uploadArtifact(ctx, step, client, src, name)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(output, "uploaded %s (%d bytes)\n%s\n", name, n, link)
+		}
+	}
+	return nil
+}
+
+// helper function uploads the artifact to the artifacts bucket
+// and returns a link to download the artifact. The artifact is
+// stored under the repository, build and stage number, and is
+// streamed from the artifacts directory, if configured, or from
+// the instance.
+func (e *Engine) uploadArtifact(ctx context.Context, step *Step, client transport, src, name string) (int64, string, error) {
+	creds := cache.Credentials{
+		Region:   e.ArtifactsRegion,
+		Endpoint: e.ArtifactsEndpoint,
+	}
+	key := path.Join(
+		step.Envs["DRONE_REPO"],
+		step.Envs["DRONE_BUILD_NUMBER"],
+		step.Envs["DRONE_STAGE_NUMBER"],
+		name,
+	)
+
+	var r *countingReader
+	var err error
+	if e.ArtifactsDir != "" {
+		// the artifact was already downloaded to the
+		// directory, and is not copied from the instance twice.
+		var f *os.File
+		f, err = openArtifact(e.ArtifactsDir, name)
+		if err != nil {
+			return 0, "", err
+		}
+		defer f.Close()
+		r = &countingReader{r: f}
+		err = cache.Upload(ctx, creds, e.ArtifactsBucket, key, r)
+	} else {
+		pr, pw := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			pw.CloseWithError(client.DownloadTo(ctx, src, pw))
+			close(done)
+		}()
+		r = &countingReader{r: pr}
+		err = cache.Upload(ctx, creds, e.ArtifactsBucket, key, r)
+		pr.CloseWithError(err)
+		<-done
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	link, err := cache.Presign(creds, e.ArtifactsBucket, key, artifactExpiry)
+	return r.n, link, err
+}
+
+// helper function copies the artifact from the instance to the
+// directory, and returns the number of bytes copied.
+func downloadArtifact(ctx context.Context, client transport, src, dir, name string) (int64, error) {
+	target, err := artifactPath(dir, name)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	w := &countingWriter{w: f}
+	err = client.DownloadTo(ctx, src, w)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return w.n, err
+}
+
+// helper function opens the artifact in the directory.
+func openArtifact(dir, name string) (*os.File, error) {
+	target, err := artifactPath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(target)
+}
+
+// helper function returns the local path of the artifact in
+// the directory. The artifact path cannot escape the directory.
+func artifactPath(dir, name string) (string, error) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "", errors.New("invalid artifact path")
+	}
+	return filepath.Join(dir, filepath.FromSlash(name)), nil
+}
+
+// countingWriter counts the bytes written to the writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// helper function parses the artifact list, returning the
+// relative file paths using forward slashes.
+func parseList(s string) []string {
+	var files []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.Replace(line, "\\", "/", -1)
+		line = strings.TrimPrefix(line, "./")
+		if line != "" && !seen[line] {
+			seen[line] = true
+			files = append(files, line)
+		}
+	}
+	return files
+}
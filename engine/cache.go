@@ -0,0 +1,222 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/cache"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/pipeline/runtime"
+)
+
+// helper function runs the cache step, which restores the
+// cache archive before the first step, or saves the cache
+// archive after the last step. Cache errors are written to the
+// step log, but do not fail the pipeline.
+func (e *Engine) runCache(ctx context.Context, spec *Spec, step *Step, client transport, output io.Writer) (*runtime.State, error) {
+	creds := cache.Credentials{
+		Client:     spec.Account.AccessKeyID,
+		Secret:     spec.Account.AccessKeySecret,
+		RoleARN:    spec.Account.RoleARN,
+		ExternalID: spec.Account.ExternalID,
+		Region:     step.Cache.Region,
+		Endpoint:   e.CacheEndpoint,
+	}
+
+	var err error
+	if step.Cache.Rebuild {
+		err = rebuildCache(ctx, spec, step, client, creds, output)
+	} else {
+		err = restoreCache(ctx, spec, step, client, creds, output)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			WithField("step", step.Name).
+			Warn("cache step failed")
+		fmt.Fprintf(output, "cache error: %s\n", err)
+	}
+	return &runtime.State{
+		ExitCode: 0,
+		Exited:   true,
+	}, nil
+}
+
+// helper function restores the cache archive matching the
+// cache key, or the restore keys, to the working directory.
+func restoreCache(ctx context.Context, spec *Spec, step *Step, client transport, creds cache.Credentials, output io.Writer) error {
+	key, err := expandKey(ctx, spec, step, client, step.Cache.Key)
+	if err != nil {
+		return err
+	}
+	key = namespaceKey(step, key)
+	var restoreKeys []string
+	for _, s := range step.Cache.RestoreKeys {
+		s, err := expandKey(ctx, spec, step, client, s)
+		if err != nil {
+			return err
+		}
+		restoreKeys = append(restoreKeys, namespaceKey(step, s))
+	}
+
+	found, err := cache.Find(ctx, creds, step.Cache.Bucket, key, restoreKeys)
+	if err != nil {
+		return err
+	}
+	if found == "" {
+		fmt.Fprintf(output, "cache miss for key %s\n", key)
+		return nil
+	}
+	// the archive is streamed from the bucket to the instance
+	// so that large archives are not buffered in memory.
+	archive := tempPath(spec.Platform.OS) + ".tar.gz"
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	var size int64
+	go func() {
+		n, err := cache.Download(ctx, creds, step.Cache.Bucket, found, pw)
+		size = n
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	uerr := client.UploadFrom(ctx, archive, pr, 0600)
+	pr.CloseWithError(uerr)
+	if err := <-done; err != nil {
+		return err
+	}
+	if uerr != nil {
+		return uerr
+	}
+	code, err := client.Exec(ctx, extractScript(spec.Platform.OS, archive, step.WorkingDir), output)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("cannot extract cache archive: exit code %d", code)
+	}
+	fmt.Fprintf(output, "restored cache %s (%d bytes)\n", found, size)
+	return nil
+}
+
+// helper function saves the cache paths in the working
+// directory to the cache archive. The archive is not saved if
+// an archive already exists for the cache key.
+func rebuildCache(ctx context.Context, spec *Spec, step *Step, client transport, creds cache.Credentials, output io.Writer) error {
+	key, err := expandKey(ctx, spec, step, client, step.Cache.Key)
+	if err != nil {
+		return err
+	}
+	key = namespaceKey(step, key)
+	exists, err := cache.Exists(ctx, creds, step.Cache.Bucket, key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		fmt.Fprintf(output, "cache %s already exists, skipping\n", key)
+		return nil
+	}
+
+	archive := tempPath(spec.Platform.OS) + ".tar.gz"
+	code, err := client.Exec(ctx, archiveScript(spec.Platform.OS, archive, step.WorkingDir, step.Cache.Paths), output)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("cannot create cache archive: exit code %d", code)
+	}
+	// the archive is streamed from the instance to the bucket
+	// so that large archives are not buffered in memory.
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		pw.CloseWithError(client.DownloadTo(ctx, archive, pw))
+		close(done)
+	}()
+	r := &countingReader{r: pr}
+	err = cache.Upload(ctx, creds, step.Cache.Bucket, key, r)
+	pr.CloseWithError(err)
+	<-done
+	client.Exec(ctx, removeScript(spec.Platform.OS, archive), ioutil.Discard)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "saved cache %s (%d bytes)\n", key, r.n)
+	return nil
+}
+
+// helper function returns the cache key in the namespace of
+// the repository, so that repositories that share a bucket
+// cannot restore or overwrite the cache of another repository.
+func namespaceKey(step *Step, key string) string {
+	if step.Cache.Namespace == "" {
+		return key
+	}
+	return step.Cache.Namespace + "/" + key
+}
+
+// countingReader counts the bytes read from the reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// helper function expands the cache key template. The template
+// can reference the checksum of a workspace file, the step
+// environment, the platform and the current time.
+func expandKey(ctx context.Context, spec *Spec, step *Step, client transport, key string) (string, error) {
+	funcs := template.FuncMap{
+		"checksum": func(name string) (string, error) {
+			if !path.IsAbs(name) {
+				name = path.Join(step.WorkingDir, name)
+			}
+			data, err := client.ReadFile(ctx, name, 0)
+			if err != nil {
+				return "", fmt.Errorf("cannot checksum %s: %s", name, err)
+			}
+			return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+		},
+		"env": func(name string) string {
+			return step.Envs[name]
+		},
+		"epoch": func() int64 {
+			return time.Now().Unix()
+		},
+		"os": func() string {
+			return spec.Platform.OS
+		},
+		"arch": func() string {
+			return spec.Platform.Arch
+		},
+	}
+	t, err := template.New("key").Funcs(funcs).Parse(key)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, nil); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
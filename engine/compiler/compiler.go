@@ -7,15 +7,22 @@ package compiler
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/drone-runners/drone-runner-aws/engine"
 	"github.com/drone-runners/drone-runner-aws/engine/resource"
+	"github.com/drone-runners/drone-runner-aws/internal/account"
+	"github.com/drone-runners/drone-runner-aws/internal/pool"
 
+	"github.com/drone/drone-go/drone"
 	"github.com/drone/runner-go/clone"
 	"github.com/drone/runner-go/environ"
 	"github.com/drone/runner-go/environ/provider"
 	"github.com/drone/runner-go/manifest"
 	"github.com/drone/runner-go/pipeline/runtime"
+	"github.com/drone/runner-go/registry"
+	"github.com/drone/runner-go/registry/auths"
 	"github.com/drone/runner-go/secret"
 
 	"github.com/dchest/uniuri"
@@ -27,11 +34,20 @@ var random = func() string {
 	return "drone-" + uniuri.NewLen(20)
 }
 
+// default timeout of the service readiness check.
+const defaultReadyTimeout = time.Minute * 5
+
 // Settings defines default settings.
 type Settings struct {
-	// TODO replace or remove
-	Param1 string
-	Param2 string
+	// Userdata provides the default custom userdata used
+	// when the pipeline does not define userdata.
+	Userdata string
+
+	// DefaultCredentials allows pipelines that do not define
+	// account credentials to use the default credential chain
+	// of the runner, which includes the instance profile of
+	// the runner.
+	DefaultCredentials bool
 }
 
 // Compiler compiles the Yaml configuration file to an
@@ -45,9 +61,24 @@ type Compiler struct {
 	// into the pipeline step.
 	Secret secret.Provider
 
+	// Registry returns a list of registry credentials that can be
+	// used to pull private container images.
+	Registry registry.Provider
+
 	// Settings provides global settings that apply to
 	// all pipelines.
 	Settings Settings
+
+	// Pools provides the named instance pools that pipelines
+	// can reference instead of defining the account and
+	// instance settings.
+	Pools pool.Pools
+
+	// Accounts provides the named accounts that pipelines
+	// can reference instead of defining the credentials. If
+	// accounts are configured, pipelines that do not use a
+	// pool must reference a named account.
+	Accounts account.Accounts
 }
 
 // Compile compiles the configuration file.
@@ -55,6 +86,27 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 	pipeline := args.Pipeline.(*resource.Pipeline)
 	os := pipeline.Platform.OS
 
+	// merge the account and instance settings of the named
+	// pool, if defined, into the pipeline.
+	// the pools and accounts can change when the runner
+	// configuration is reloaded after the pipeline is linted,
+	// in which case the pipeline fails instead of falling
+	// back to the pipeline credentials.
+	if name := pipeline.Pool; name != "" {
+		p, ok := c.Pools.Lookup(name)
+		if !ok {
+			return &engine.Spec{Error: fmt.Sprintf("unknown pool %s", name)}
+		}
+		pipeline = p.Apply(pipeline)
+	}
+	if name := pipeline.Account.Name; name != "" {
+		if _, ok := c.Accounts.Lookup(name); !ok {
+			return &engine.Spec{Error: fmt.Sprintf("unknown account %s", name)}
+		}
+	} else if len(c.Accounts) != 0 && pipeline.Pool == "" {
+		return &engine.Spec{Error: "pipeline must use a named account"}
+	}
+
 	spec := &engine.Spec{
 		Platform: engine.Platform{
 			OS:      pipeline.Platform.OS,
@@ -68,9 +120,12 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 			Region:          pipeline.Account.Region,
 		},
 		Instance: engine.Instance{
-			AMI:    pipeline.Instance.AMI,
-			Type:   pipeline.Instance.Type,
-			Market: pipeline.Instance.Market,
+			AMI:           pipeline.Instance.AMI,
+			Type:          pipeline.Instance.Type,
+			Market:        pipeline.Instance.Market,
+			Transport:     pipeline.Instance.Transport,
+			Userdata:      pipeline.Instance.Userdata,
+			IAMProfileARN: pipeline.Instance.IAMProfileARN,
 			Network: engine.Network{
 				VPC:               pipeline.Instance.Network.VPC,
 				VPCSecurityGroups: pipeline.Instance.Network.VPCSecurityGroups,
@@ -87,6 +142,7 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 				Name: pipeline.Instance.Device.Name,
 			},
 		},
+		Timeout: pipeline.Timeout,
 	}
 
 	// maybe source the aws_access_key_id from a secret.
@@ -99,6 +155,29 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		spec.Account.AccessKeySecret = s
 	}
 
+	// source the credentials from the named account, if
+	// defined. The pipeline can override the account region.
+	if name := pipeline.Account.Name; name != "" {
+		a, _ := c.Accounts.Lookup(name)
+		spec.Account = engine.Account{
+			AccessKeyID:     a.AccessKeyID,
+			AccessKeySecret: a.AccessKeySecret,
+			RoleARN:         a.RoleARN,
+			ExternalID:      a.ExternalID,
+			Region:          a.Region,
+		}
+		if s := pipeline.Account.Region; s != "" {
+			spec.Account.Region = s
+		}
+	}
+
+	// the default credential chain of the runner is used if
+	// allowed by the runner, or if the credentials are
+	// sourced from a named account or pool, which are
+	// configured by the runner.
+	spec.Account.Default = c.Settings.DefaultCredentials ||
+		pipeline.Account.Name != "" || pipeline.Pool != ""
+
 	// set default instance type if not provided
 	if spec.Instance.Type == "" {
 		spec.Instance.Type = "t3.nano"
@@ -122,9 +201,13 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		spec.Instance.Disk.Type = "gp2"
 	}
 
-	// set the default iops
-	if spec.Instance.Disk.Type == "io1" && spec.Instance.Disk.Iops == 0 {
-		spec.Instance.Disk.Iops = 100
+	// set the default iops, which are required for
+	// provisioned iops disks.
+	switch spec.Instance.Disk.Type {
+	case "io1", "io2":
+		if spec.Instance.Disk.Iops == 0 {
+			spec.Instance.Disk.Iops = 100
+		}
 	}
 
 	// set the default device
@@ -141,6 +224,17 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		spec.Instance.User = "root"
 	}
 
+	// set the default transport used to connect to the
+	// instance if not provided.
+	if spec.Instance.Transport == "" {
+		spec.Instance.Transport = "ssh"
+	}
+
+	// set the default userdata if not provided
+	if spec.Instance.Userdata == "" {
+		spec.Instance.Userdata = c.Settings.Userdata
+	}
+
 	// create the root directory
 	spec.Root = tempdir(os)
 
@@ -159,20 +253,21 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		IsDir: true,
 	})
 
-	// creates a source directory in the root.
+	// creates a source directory in the root. the source
+	// directory defaults to drone/src and may be relocated
+	// by the workspace path.
 	// note: mkdirall fails on windows so we need to create all
 	// directories in the tree.
-	sourcedir := join(os, spec.Root, "drone", "src")
-	spec.Files = append(spec.Files, &engine.File{
-		Path:  join(os, spec.Root, "drone"),
-		Mode:  0700,
-		IsDir: true,
-	})
-	spec.Files = append(spec.Files, &engine.File{
-		Path:  sourcedir,
-		Mode:  0700,
-		IsDir: true,
-	})
+	workspace := getWorkspace(pipeline)
+	sourcedir := spec.Root
+	for _, dir := range workspace {
+		sourcedir = join(os, sourcedir, dir)
+		spec.Files = append(spec.Files, &engine.File{
+			Path:  sourcedir,
+			Mode:  0700,
+			IsDir: true,
+		})
+	}
 
 	// creates the opt directory to hold all scripts.
 	spec.Files = append(spec.Files, &engine.File{
@@ -198,6 +293,24 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		})
 	}
 
+	// creates the docker config file with the registry
+	// credentials, so that private images are pulled without
+	// exposing the credentials as environment variables.
+	var dockerconfig string
+	if creds := c.findCreds(ctx, args, pipeline); len(creds) != 0 {
+		dockerconfig = join(os, homedir, ".docker")
+		spec.Files = append(spec.Files, &engine.File{
+			Path:  dockerconfig,
+			Mode:  0700,
+			IsDir: true,
+		})
+		spec.Files = append(spec.Files, &engine.File{
+			Path: join(os, dockerconfig, "config.json"),
+			Mode: 0600,
+			Data: []byte(auths.Encode(creds...)),
+		})
+	}
+
 	// list the global environment variables
 	globals, _ := c.Environ.List(ctx, &provider.Request{
 		Build: args.Build,
@@ -265,6 +378,7 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 			Name:      "clone",
 			Args:      args,
 			Command:   cmd,
+			Shell:     getShell(os, ""),
 			Envs:      envs,
 			RunPolicy: runtime.RunAlways,
 			Files: []*engine.File{
@@ -279,23 +393,46 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		})
 	}
 
-	// create steps
-	for _, src := range pipeline.Steps {
+	// create the restore cache step, maybe. the cache is
+	// restored after the clone step, so that the cache key
+	// can reference the checksum of files in the workspace.
+	if len(pipeline.Cache.Paths) != 0 {
+		spec.Steps = append(spec.Steps, &engine.Step{
+			Name:       "restore-cache",
+			Envs:       envs,
+			RunPolicy:  runtime.RunOnSuccess,
+			Secrets:    []*engine.Secret{},
+			WorkingDir: sourcedir,
+			Cache:      convertCache(pipeline, args.Repo.Slug, spec.Account.Region, false),
+		})
+	}
+
+	// create services and steps. services are compiled into
+	// detached steps that start before the first pipeline step.
+	var services []*engine.Step
+	var sources []*resource.Step
+	sources = append(sources, pipeline.Services...)
+	sources = append(sources, pipeline.Steps...)
+	for i, src := range sources {
+		shell := getShell(os, src.Shell)
 		buildslug := slug.Make(src.Name)
-		buildpath := join(os, spec.Root, "opt", getExt(os, buildslug))
-		buildfile := genScript(os, src.Commands)
+		buildpath := join(os, spec.Root, "opt", getShellExt(shell, buildslug))
+		buildfile := genShellScript(shell, src.Commands)
 
-		cmd, args := getCommand(os, buildpath)
+		cmd, args := getShellCommand(os, shell, buildpath)
 		dst := &engine.Step{
 			Name:      src.Name,
 			Args:      args,
 			Command:   cmd,
+			Shell:     shell,
+			Timeout:   src.Timeout,
 			Detach:    src.Detach,
 			DependsOn: src.DependsOn,
 			Envs: environ.Combine(envs,
 				environ.Expand(
 					convertStaticEnv(src.Environment),
 				),
+				convertStaticSettings(src.Settings),
 			),
 			RunPolicy: runtime.RunOnSuccess,
 			Files: []*engine.File{
@@ -305,10 +442,46 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 					Data: []byte(buildfile),
 				},
 			},
-			Secrets:    convertSecretEnv(src.Environment),
+			Secrets: append(
+				convertSecretEnv(src.Environment),
+				convertSecretSettings(src.Settings)...,
+			),
 			WorkingDir: sourcedir,
 		}
-		spec.Steps = append(spec.Steps, dst)
+
+		if i < len(pipeline.Services) {
+			dst.Detach = true
+			dst.Ready = convertReady(src.Ready)
+			services = append(services, dst)
+		} else {
+			spec.Steps = append(spec.Steps, dst)
+		}
+
+		// set the step working directory, relative to the
+		// source directory.
+		if src.WorkingDir != "" {
+			dirs := splitPath(strings.Join(workspace, "/"), src.WorkingDir)
+			dst.WorkingDir = join(os, append([]string{spec.Root}, dirs...)...)
+		}
+
+		// image steps are executed in a docker container on
+		// the instance, using the step script as the container
+		// entrypoint. plugin steps, which define an image but
+		// no commands, use the image entrypoint and receive the
+		// step environment from the host script.
+		if src.Image != "" {
+			dst.Image = src.Image
+			dst.Pull = src.Pull
+			dst.Config = dockerconfig
+			dst.Container = random() + "-" + buildslug
+			if len(src.Commands) == 0 {
+				dst.Files[0].Data = []byte(
+					genPluginScript(spec.Root, dst.Container, dst.Image, dst.WorkingDir, getPluginEnvs(dst)),
+				)
+			} else {
+				dst.Command, dst.Args = getDockerCommand(spec.Root, dst.Container, src.Image, cmd, args)
+			}
+		}
 
 		// set the pipeline step run policy. steps run on
 		// success by default, but may be optionally configured
@@ -319,6 +492,15 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 			dst.RunPolicy = runtime.RunOnFailure
 		}
 
+		// set the pipeline failure policy. steps can choose
+		// to ignore the failure, or fail fast.
+		switch src.Failure {
+		case "ignore":
+			dst.ErrPolicy = runtime.ErrIgnore
+		case "fast", "fast-fail", "fail-fast":
+			dst.ErrPolicy = runtime.ErrFailFast
+		}
+
 		// if the pipeline step has unmet conditions the step is
 		// automatically skipped.
 		if !src.When.Match(match) {
@@ -326,13 +508,49 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		}
 	}
 
-	if isGraph(spec) == false {
+	// create the rebuild cache step, maybe. the cache is
+	// saved after the last step. pull requests and forks can
+	// restore the cache, but cannot rebuild it, because the
+	// cache namespace is shared with the trusted branches.
+	if len(pipeline.Cache.Paths) != 0 && !untrustedBuild(args.Repo, args.Build) {
+		spec.Steps = append(spec.Steps, &engine.Step{
+			Name:       "rebuild-cache",
+			Envs:       envs,
+			RunPolicy:  runtime.RunOnSuccess,
+			Secrets:    []*engine.Secret{},
+			WorkingDir: sourcedir,
+			Cache:      convertCache(pipeline, args.Repo.Slug, spec.Account.Region, true),
+		})
+	}
+
+	// create the artifacts step, maybe. the artifacts are
+	// collected after the last step, even if the pipeline
+	// fails, and before the instance is destroyed.
+	if len(pipeline.Artifacts) != 0 {
+		spec.Steps = append(spec.Steps, &engine.Step{
+			Name:       "artifacts",
+			Envs:       envs,
+			RunPolicy:  runtime.RunAlways,
+			Secrets:    []*engine.Secret{},
+			WorkingDir: sourcedir,
+			Artifacts:  pipeline.Artifacts,
+		})
+	}
+	spec.Steps = append(services, spec.Steps...)
+
+	graph := isGraph(spec)
+	if graph == false {
 		configureSerial(spec)
 	} else if pipeline.Clone.Disable == false {
 		configureCloneDeps(spec)
 	} else if pipeline.Clone.Disable == true {
 		removeCloneDeps(spec)
 	}
+	if graph {
+		configureCacheDeps(spec)
+		configureArtifactDeps(spec)
+		configureServiceDeps(spec, services)
+	}
 
 	for _, step := range spec.Steps {
 		for _, s := range step.Secrets {
@@ -346,6 +564,35 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 	return spec
 }
 
+// helper function returns the registry credentials from the
+// registry provider and from the pipeline image pull secrets.
+func (c *Compiler) findCreds(ctx context.Context, args runtime.CompilerArgs, pipeline *resource.Pipeline) []*drone.Registry {
+	var creds []*drone.Registry
+	// source credentials from the registry provider. please
+	// note we currently ignore errors, consistent with the
+	// environment provider.
+	if c.Registry != nil {
+		creds, _ = c.Registry.List(ctx, &registry.Request{
+			Repo:  args.Repo,
+			Build: args.Build,
+		})
+	}
+	// source credentials from the image pull secrets. the
+	// secrets take precedence over the registry provider.
+	for _, name := range pipeline.PullSecrets {
+		s, ok := c.findSecret(ctx, args, name)
+		if !ok {
+			continue
+		}
+		parsed, err := auths.ParseString(s)
+		if err != nil {
+			continue
+		}
+		creds = append(creds, parsed...)
+	}
+	return creds
+}
+
 // helper function attempts to find and return the named secret.
 // from the secret provider.
 func (c *Compiler) findSecret(ctx context.Context, args runtime.CompilerArgs, name string) (s string, ok bool) {
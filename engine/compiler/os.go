@@ -7,9 +7,6 @@ package compiler
 import (
 	"fmt"
 	"strings"
-
-	"github.com/drone/runner-go/shell/bash"
-	"github.com/drone/runner-go/shell/powershell"
 )
 
 // helper function returns the base temporary directory based
@@ -37,23 +34,13 @@ func join(os string, paths ...string) string {
 // helper function returns the shell extension based on the
 // target platform.
 func getExt(os, file string) (s string) {
-	switch os {
-	case "windows":
-		return file + ".ps1"
-	default:
-		return file
-	}
+	return getShellExt(getShell(os, ""), file)
 }
 
 // helper function returns the shell command and arguments
 // based on the target platform to invoke the script
 func getCommand(os, script string) (string, []string) {
-	cmd, args := bash.Command()
-	switch os {
-	case "windows":
-		cmd, args = powershell.Command()
-	}
-	return cmd, append(args, script)
+	return getShellCommand(os, getShell(os, ""), script)
 }
 
 // helper function returns the netrc file name based on the
@@ -72,10 +59,5 @@ func getNetrc(os string) string {
 // language (bash vs pwoershell) is determined by the operating
 // system.
 func genScript(os string, commands []string) string {
-	switch os {
-	case "windows":
-		return powershell.Script(commands)
-	default:
-		return bash.Script(commands)
-	}
+	return genShellScript(getShell(os, ""), commands)
 }
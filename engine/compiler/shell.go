@@ -0,0 +1,128 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/drone/runner-go/shell/bash"
+	"github.com/drone/runner-go/shell/powershell"
+)
+
+// helper function returns the shell used to execute the
+// pipeline step. If the shell is not defined, the default
+// shell for the target platform is returned.
+func getShell(os, shell string) string {
+	switch {
+	case shell != "":
+		return shell
+	case os == "windows":
+		return "powershell"
+	default:
+		return "sh"
+	}
+}
+
+// helper function returns the file name with the script
+// extension expected by the shell.
+func getShellExt(shell, file string) string {
+	switch shell {
+	case "powershell", "pwsh":
+		return file + powershell.Suffix
+	case "cmd":
+		return file + ".bat"
+	case "python":
+		return file + ".py"
+	default:
+		return file + bash.Suffix
+	}
+}
+
+// helper function returns the shell command and arguments
+// used to invoke the script.
+func getShellCommand(os, shell, script string) (string, []string) {
+	var cmd string
+	var args []string
+	switch shell {
+	case "powershell":
+		cmd, args = powershell.Command()
+	case "pwsh":
+		_, args = powershell.Command()
+		cmd = "pwsh"
+	case "cmd":
+		cmd, args = "cmd", []string{"/c"}
+	case "python":
+		cmd = "python3"
+		if os == "windows" {
+			cmd = "python"
+		}
+	case "bash":
+		_, args = bash.Command()
+		cmd = "/bin/bash"
+	default:
+		cmd, args = bash.Command()
+	}
+	return cmd, append(args, script)
+}
+
+// helper function generates and returns a script, in the
+// scripting language of the shell, to execute the provided
+// commands.
+func genShellScript(shell string, commands []string) string {
+	switch shell {
+	case "powershell", "pwsh":
+		return powershell.Script(commands)
+	case "cmd":
+		return cmdScript(commands)
+	case "python":
+		return pythonScript(commands)
+	default:
+		return bash.Script(commands)
+	}
+}
+
+// helper function generates a batch script that echoes and
+// executes each command, exiting on the first failure.
+func cmdScript(commands []string) string {
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf)
+	for _, command := range commands {
+		fmt.Fprintf(buf, cmdTraceScript, cmdEscape(command), command)
+	}
+	return buf.String()
+}
+
+// helper function generates a python script. Commands are
+// python statements and are not traced, since tracing would
+// break multi-line statements.
+func pythonScript(commands []string) string {
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf)
+	for _, command := range commands {
+		fmt.Fprintln(buf, command)
+	}
+	return buf.String()
+}
+
+// helper function escapes the batch special characters so
+// the command can be echoed.
+func cmdEscape(s string) string {
+	return strings.NewReplacer(
+		"^", "^^",
+		"&", "^&",
+		"|", "^|",
+		"<", "^<",
+		">", "^>",
+		"%", "%%",
+	).Replace(s)
+}
+
+const cmdTraceScript = `
+echo + %s
+%s
+if %%errorlevel%% neq 0 exit /b %%errorlevel%%
+`
@@ -0,0 +1,99 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/drone/runner-go/shell/bash"
+	"github.com/drone/runner-go/shell/powershell"
+)
+
+func Test_getShell(t *testing.T) {
+	tests := []struct {
+		os    string
+		shell string
+		want  string
+	}{
+		{os: "linux", shell: "", want: "sh"},
+		{os: "windows", shell: "", want: "powershell"},
+		{os: "linux", shell: "pwsh", want: "pwsh"},
+		{os: "windows", shell: "cmd", want: "cmd"},
+	}
+	for _, test := range tests {
+		if got := getShell(test.os, test.shell); got != test.want {
+			t.Errorf("Want shell %s, got %s", test.want, got)
+		}
+	}
+}
+
+func Test_getShellExt(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  string
+	}{
+		{shell: "sh", want: "build"},
+		{shell: "bash", want: "build"},
+		{shell: "powershell", want: "build.ps1"},
+		{shell: "pwsh", want: "build.ps1"},
+		{shell: "cmd", want: "build.bat"},
+		{shell: "python", want: "build.py"},
+	}
+	for _, test := range tests {
+		if got := getShellExt(test.shell, "build"); got != test.want {
+			t.Errorf("Want file %s for shell %s, got %s", test.want, test.shell, got)
+		}
+	}
+}
+
+func Test_getShellCommand(t *testing.T) {
+	tests := []struct {
+		os    string
+		shell string
+		cmd   string
+		args  []string
+	}{
+		{os: "linux", shell: "sh", cmd: "/bin/sh", args: []string{"-e", "build"}},
+		{os: "linux", shell: "bash", cmd: "/bin/bash", args: []string{"-e", "build"}},
+		{os: "linux", shell: "pwsh", cmd: "pwsh", args: []string{"-noprofile", "-noninteractive", "-command", "build"}},
+		{os: "linux", shell: "python", cmd: "python3", args: []string{"build"}},
+		{os: "windows", shell: "powershell", cmd: "powershell", args: []string{"-noprofile", "-noninteractive", "-command", "build"}},
+		{os: "windows", shell: "cmd", cmd: "cmd", args: []string{"/c", "build"}},
+		{os: "windows", shell: "python", cmd: "python", args: []string{"build"}},
+	}
+	for _, test := range tests {
+		cmd, args := getShellCommand(test.os, test.shell, "build")
+		if cmd != test.cmd {
+			t.Errorf("Want command %s for shell %s, got %s", test.cmd, test.shell, cmd)
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("Want args %v for shell %s, got %v", test.args, test.shell, args)
+		}
+	}
+}
+
+func Test_genShellScript(t *testing.T) {
+	commands := []string{"go build"}
+
+	if got, want := genShellScript("pwsh", commands), powershell.Script(commands); got != want {
+		t.Errorf("Generated invalid pwsh script")
+	}
+	if got, want := genShellScript("bash", commands), bash.Script(commands); got != want {
+		t.Errorf("Generated invalid bash script")
+	}
+
+	got := genShellScript("cmd", []string{"echo a > b"})
+	want := "\n\necho + echo a ^> b\necho a > b\nif %errorlevel% neq 0 exit /b %errorlevel%\n"
+	if got != want {
+		t.Errorf("Want cmd script %q, got %q", want, got)
+	}
+
+	got = genShellScript("python", []string{"import sys", "print(sys.version)"})
+	want = "\nimport sys\nprint(sys.version)\n"
+	if got != want {
+		t.Errorf("Want python script %q, got %q", want, got)
+	}
+}
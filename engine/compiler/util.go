@@ -5,6 +5,10 @@
 package compiler
 
 import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
 	"strings"
 
 	"github.com/drone-runners/drone-runner-aws/engine"
@@ -93,6 +97,152 @@ func convertSecretEnv(src map[string]*manifest.Variable) []*engine.Secret {
 	return dst
 }
 
+// helper function converts the plugin settings to a map of
+// PLUGIN_ environment variables, returning only inline settings
+// not derived from a secret.
+func convertStaticSettings(src map[string]*manifest.Parameter) map[string]string {
+	dst := map[string]string{}
+	for k, v := range src {
+		if v == nil {
+			continue
+		}
+		if strings.TrimSpace(v.Secret) == "" {
+			dst[getPluginEnv(k)] = encodeParam(v.Value)
+		}
+	}
+	return dst
+}
+
+// helper function converts the plugin settings to a list of
+// masked secrets, returning only settings derived from a
+// secret.
+func convertSecretSettings(src map[string]*manifest.Parameter) []*engine.Secret {
+	dst := []*engine.Secret{}
+	for k, v := range src {
+		if v == nil {
+			continue
+		}
+		if strings.TrimSpace(v.Secret) != "" {
+			dst = append(dst, &engine.Secret{
+				Name: v.Secret,
+				Mask: true,
+				Env:  getPluginEnv(k),
+			})
+		}
+	}
+	return dst
+}
+
+// helper function returns the PLUGIN_ environment variable
+// name for the plugin setting.
+func getPluginEnv(name string) string {
+	name = strings.Replace(name, ".", "_", -1)
+	name = strings.Replace(name, "-", "_", -1)
+	return "PLUGIN_" + strings.ToUpper(name)
+}
+
+// helper function encodes the plugin setting value as a
+// string. Scalar values are formatted, lists of scalar values
+// are comma separated, and all other values are encoded as
+// json.
+func encodeParam(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool, int, int64, float64:
+		return fmt.Sprint(v)
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			switch item.(type) {
+			case string, bool, int, int64, float64:
+				parts = append(parts, fmt.Sprint(item))
+			default:
+				return encodeJSON(v)
+			}
+		}
+		return strings.Join(parts, ",")
+	default:
+		return encodeJSON(v)
+	}
+}
+
+// helper function encodes the value as json. Yaml maps are
+// decoded with interface keys, which are converted to strings
+// before encoding.
+func encodeJSON(v interface{}) string {
+	b, _ := json.Marshal(convertKeys(v))
+	return string(b)
+}
+
+// helper function recursively converts yaml maps with
+// interface keys to maps with string keys.
+func convertKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		dst := map[string]interface{}{}
+		for k, item := range v {
+			dst[fmt.Sprint(k)] = convertKeys(item)
+		}
+		return dst
+	case []interface{}:
+		dst := make([]interface{}, len(v))
+		for i, item := range v {
+			dst[i] = convertKeys(item)
+		}
+		return dst
+	default:
+		return v
+	}
+}
+
+// helper function returns the sorted names of the environment
+// variables and secrets passed to the plugin container.
+func getPluginEnvs(step *engine.Step) []string {
+	var names []string
+	for k := range step.Envs {
+		names = append(names, k)
+	}
+	for _, s := range step.Secrets {
+		names = append(names, s.Env)
+	}
+	// the instance details are injected when the instance is
+	// provisioned, after the pipeline is compiled.
+	for _, name := range engine.RuntimeEnviron {
+		if _, ok := step.Envs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// helper function generates the host script that runs the
+// plugin container. The environment variables are exported by
+// the script header, and passed to the container by name, so
+// that values are never written to the command line.
+func genPluginScript(root, name, image, workdir string, envs []string) string {
+	args := []string{
+		"docker", "run",
+		"--rm",
+		"--init",
+		"--name", name,
+		"--network", "host",
+		"--volume", root + ":" + root,
+		"--workdir", workdir,
+	}
+	for _, env := range envs {
+		args = append(args, "--env", env)
+	}
+	args = append(args, image)
+	for i, arg := range args {
+		args[i] = "'" + strings.Replace(arg, "'", `'"'"'`, -1) + "'"
+	}
+	return "\nset -e\n\n" + strings.Join(args, " ") + "\n"
+}
+
 // helper function modifies the pipeline dependency graph to
 // account for the clone step.
 func configureCloneDeps(spec *engine.Spec) {
@@ -106,6 +256,154 @@ func configureCloneDeps(spec *engine.Spec) {
 	}
 }
 
+// helper function modifies the pipeline dependency graph to
+// account for services. services do not depend on the clone
+// step, and the root steps depend on all services.
+func configureServiceDeps(spec *engine.Spec, services []*engine.Step) {
+	if len(services) == 0 {
+		return
+	}
+	var names []string
+	isService := map[*engine.Step]bool{}
+	for _, service := range services {
+		names = append(names, service.Name)
+		isService[service] = true
+		if len(service.DependsOn) == 1 &&
+			service.DependsOn[0] == "clone" {
+			service.DependsOn = nil
+		}
+	}
+	for _, step := range spec.Steps {
+		if !isService[step] && len(step.DependsOn) == 0 {
+			step.DependsOn = names
+		}
+	}
+}
+
+// helper function modifies the pipeline dependency graph to
+// account for the cache steps. the root steps depend on the
+// restore cache step, and the rebuild cache step depends on
+// all steps that are not detached.
+func configureCacheDeps(spec *engine.Spec) {
+	var restore, rebuild *engine.Step
+	for _, step := range spec.Steps {
+		if step.Cache == nil {
+			continue
+		}
+		if step.Cache.Rebuild {
+			rebuild = step
+		} else {
+			restore = step
+		}
+	}
+	if restore == nil || rebuild == nil {
+		return
+	}
+	var names []string
+	for _, step := range spec.Steps {
+		if step == restore || step == rebuild ||
+			step.Name == "clone" || step.Detach ||
+			len(step.Artifacts) != 0 {
+			continue
+		}
+		names = append(names, step.Name)
+		if len(step.DependsOn) == 0 ||
+			(len(step.DependsOn) == 1 && step.DependsOn[0] == "clone") {
+			step.DependsOn = []string{restore.Name}
+		}
+	}
+	rebuild.DependsOn = names
+	if len(names) == 0 {
+		rebuild.DependsOn = []string{restore.Name}
+	}
+}
+
+// helper function modifies the pipeline dependency graph to
+// account for the artifacts step, which depends on all steps
+// that are not detached.
+func configureArtifactDeps(spec *engine.Spec) {
+	var artifacts *engine.Step
+	var names []string
+	for _, step := range spec.Steps {
+		if len(step.Artifacts) != 0 {
+			artifacts = step
+		} else if !step.Detach {
+			names = append(names, step.Name)
+		}
+	}
+	if artifacts != nil && len(names) != 0 {
+		artifacts.DependsOn = names
+	}
+}
+
+// helper function returns true if the build is a pull request
+// or originates from a fork of the repository.
+func untrustedBuild(repo *drone.Repo, build *drone.Build) bool {
+	if build.Event == drone.EventPullRequest {
+		return true
+	}
+	return build.Fork != "" && build.Fork != repo.Slug
+}
+
+// helper function converts the pipeline cache, returning nil
+// if the cache is not defined. the cache region defaults to
+// the account region.
+func convertCache(pipeline *resource.Pipeline, namespace, region string, rebuild bool) *engine.Cache {
+	if len(pipeline.Cache.Paths) == 0 {
+		return nil
+	}
+	dst := &engine.Cache{
+		Rebuild:     rebuild,
+		Bucket:      pipeline.Cache.Bucket,
+		Region:      pipeline.Cache.Region,
+		Key:         pipeline.Cache.Key,
+		RestoreKeys: pipeline.Cache.RestoreKeys,
+		Paths:       pipeline.Cache.Paths,
+		Namespace:   namespace,
+	}
+	if dst.Region == "" {
+		dst.Region = region
+	}
+	return dst
+}
+
+// helper function returns the docker command and arguments
+// used to run the command in a container. The root directory is
+// mounted at the same path in the container, so that the
+// workspace and scripts are found at the same location. The
+// container uses the host network, so that it can connect to
+// services running on the instance.
+func getDockerCommand(root, name, image, cmd string, args []string) (string, []string) {
+	dockerArgs := []string{
+		"run",
+		"--rm",
+		"--init",
+		"--name", name,
+		"--network", "host",
+		"--volume", root + ":" + root,
+		"--entrypoint", cmd,
+		image,
+	}
+	return "docker", append(dockerArgs, args...)
+}
+
+// helper function converts the service readiness check,
+// returning nil if the readiness check is not defined.
+func convertReady(src resource.Ready) *engine.Ready {
+	if src.Port == 0 && src.Command == "" {
+		return nil
+	}
+	dst := &engine.Ready{
+		Port:    src.Port,
+		Command: src.Command,
+		Timeout: src.Timeout,
+	}
+	if dst.Timeout == 0 {
+		dst.Timeout = defaultReadyTimeout
+	}
+	return dst
+}
+
 // helper function modifies the pipeline dependency graph to
 // account for a disabled clone step.
 func removeCloneDeps(spec *engine.Spec) {
@@ -121,3 +419,28 @@ func removeCloneDeps(spec *engine.Spec) {
 		}
 	}
 }
+
+// helper function returns the workspace path elements, relative
+// to the root directory.
+func getWorkspace(pipeline *resource.Pipeline) []string {
+	if pipeline.Workspace.Path == "" {
+		return []string{"drone", "src"}
+	}
+	return splitPath("", pipeline.Workspace.Path)
+}
+
+// helper function cleans the path and returns the path
+// elements. Relative paths are resolved relative to the base
+// path, and absolute paths are resolved relative to the root
+// directory. The resolved path cannot escape the root.
+func splitPath(base, p string) []string {
+	p = strings.Replace(p, "\\", "/", -1)
+	if !strings.HasPrefix(p, "/") {
+		p = base + "/" + p
+	}
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return nil
+	}
+	return strings.Split(p[1:], "/")
+}
@@ -198,3 +198,57 @@ func Test_removeCloneDeps_CloneEnabled(t *testing.T) {
 		t.Log(diff)
 	}
 }
+
+func Test_splitPath(t *testing.T) {
+	tests := []struct {
+		base string
+		path string
+		want []string
+	}{
+		{base: "drone/src", path: "cmd/server", want: []string{"drone", "src", "cmd", "server"}},
+		{base: "drone/src", path: "cmd\\server", want: []string{"drone", "src", "cmd", "server"}},
+		{base: "drone/src", path: "/opt/tools", want: []string{"opt", "tools"}},
+		{base: "drone/src", path: "../lib", want: []string{"drone", "lib"}},
+		{base: "drone/src", path: "../../../../etc", want: []string{"etc"}},
+		{base: "", path: "/", want: nil},
+	}
+	for _, test := range tests {
+		got := splitPath(test.base, test.path)
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("Unexpected path elements for %s", test.path)
+			t.Log(diff)
+		}
+	}
+}
+
+func Test_getWorkspace(t *testing.T) {
+	pipeline := new(resource.Pipeline)
+	if got, want := getWorkspace(pipeline), []string{"drone", "src"}; !cmp.Equal(got, want) {
+		t.Errorf("Want default workspace %v, got %v", want, got)
+	}
+	pipeline.Workspace.Path = "/go/src/github.com/octocat/hello-world"
+	if got, want := getWorkspace(pipeline), []string{"go", "src", "github.com", "octocat", "hello-world"}; !cmp.Equal(got, want) {
+		t.Errorf("Want workspace %v, got %v", want, got)
+	}
+}
+
+func Test_encodeParam(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{value: nil, want: ""},
+		{value: "hello", want: "hello"},
+		{value: true, want: "true"},
+		{value: 42, want: "42"},
+		{value: 1.5, want: "1.5"},
+		{value: []interface{}{"a", "b", 3}, want: "a,b,3"},
+		{value: []interface{}{map[interface{}]interface{}{"a": "b"}}, want: `[{"a":"b"}]`},
+		{value: map[interface{}]interface{}{"a": []interface{}{1, 2}}, want: `{"a":[1,2]}`},
+	}
+	for _, test := range tests {
+		if got := encodeParam(test.value); got != test.want {
+			t.Errorf("Want %q for %v, got %q", test.want, test.value, got)
+		}
+	}
+}
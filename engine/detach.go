@@ -0,0 +1,132 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/pipeline/runtime"
+)
+
+// interval between polling the output and exit code of a
+// detached step.
+var detachInterval = time.Second
+
+// helper function starts the detached step in the background
+// on the instance. The step output is copied to the step log
+// until the step exits, or until the pipeline context is
+// cancelled and the step is stopped.
+func runDetached(ctx context.Context, spec *Spec, step *Step, client transport, cmd string, output io.Writer) (*runtime.State, error) {
+	base := tempPath(spec.Platform.OS)
+	code, err := client.Exec(ctx, detachScript(spec.Platform.OS, base, cmd), output)
+	if err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("cannot start detached step: exit code %d", code)
+	}
+
+	log := logger.FromContext(ctx).WithField("base", base)
+	log.Debug("detached step started")
+
+	var offset int64
+	for {
+		// the exit code is read before the output, to ensure
+		// the output is complete when the step has exited.
+		code, exited := readExitCode(ctx, client, base+".exit")
+		offset += copyOutput(ctx, client, base+".log", offset, output)
+		if exited {
+			log.WithField("exit", code).Debug("detached step exited")
+			setExited(spec, step.Name, code)
+			if code != 0 {
+				fmt.Fprintf(output, "detached step exited with code %d\n", code)
+			}
+			return &runtime.State{
+				ExitCode: code,
+				Exited:   true,
+			}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			// the pipeline context is cancelled when the
+			// pipeline completes. the detached step, and its
+			// children, are stopped.
+			stop, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+			defer cancel()
+			if _, err := client.Exec(stop, stopScript(spec.Platform.OS, base+".pid"), ioutil.Discard); err != nil {
+				log.WithError(err).Warn("cannot stop detached step")
+			}
+			copyOutput(stop, client, base+".log", offset, output)
+			log.Debug("detached step stopped")
+			return nil, ctx.Err()
+		case <-time.After(detachInterval):
+		}
+	}
+}
+
+// helper function copies the remote file to the writer,
+// starting at the offset, and returns the number of bytes
+// copied. The file may not exist when the step starts, in
+// which case nothing is copied.
+func copyOutput(ctx context.Context, client transport, path string, offset int64, output io.Writer) int64 {
+	data, err := client.ReadFile(ctx, path, offset)
+	if err != nil {
+		return 0
+	}
+	output.Write(data)
+	return int64(len(data))
+}
+
+// helper function reads the exit code of the detached step,
+// returning false if the step has not exited.
+func readExitCode(ctx context.Context, client transport, path string) (int, bool) {
+	data, err := client.ReadFile(ctx, path, 0)
+	if err != nil {
+		return 0, false
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, false
+	}
+	return code, true
+}
+
+// helper function returns an error if a detached step that
+// the step depends on exited with a non-zero exit code.
+func checkDetached(spec *Spec, step *Step) error {
+	for _, name := range step.DependsOn {
+		if code, ok := exitCode(spec, name); ok && code != 0 {
+			return fmt.Errorf("detached step %s exited with code %d", name, code)
+		}
+	}
+	return nil
+}
+
+// helper function records the exit code of the detached step.
+func setExited(spec *Spec, name string, code int) {
+	spec.mu.Lock()
+	defer spec.mu.Unlock()
+	if spec.exited == nil {
+		spec.exited = map[string]int{}
+	}
+	spec.exited[name] = code
+}
+
+// helper function returns the exit code of the detached step,
+// or false if the step has not exited.
+func exitCode(spec *Spec, name string) (int, bool) {
+	spec.mu.Lock()
+	defer spec.mu.Unlock()
+	code, ok := spec.exited[name]
+	return code, ok
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+)
+
+// cleanupTimeout bounds the cleanup commands that run after
+// the pipeline context is cancelled, so that a dead connection
+// does not block the stage teardown.
+var cleanupTimeout = time.Minute
+
+// helper function pulls the step image according to the pull
+// policy. The image is pulled if it does not exist on the
+// instance, unless the policy is always or never.
+func pullImage(ctx context.Context, client transport, step *Step, output io.Writer) error {
+	script := pullScript(step.Pull, step.Image, step.Config)
+	if script == "" {
+		return nil
+	}
+	code, err := client.Exec(ctx, script, output)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("cannot pull image %s", step.Image)
+	}
+	return nil
+}
+
+// helper function removes the step container. The container
+// is not removed when the docker client is killed, and must
+// be removed explicitly, even if the context is cancelled.
+func removeContainer(ctx context.Context, client transport, step *Step) {
+	script := fmt.Sprintf("docker rm -f %s", step.Container)
+	cleanup, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if _, err := client.Exec(cleanup, script, ioutil.Discard); err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			WithField("container", step.Container).
+			Warn("cannot remove container")
+	}
+}
+
+// helper function returns the remote command that pulls the
+// image according to the pull policy. The docker client reads
+// the registry credentials from the config directory, if
+// provided.
+func pullScript(policy, image, config string) string {
+	image = quote("sh", image)
+	pull := "docker pull"
+	if config != "" {
+		pull = fmt.Sprintf("docker --config %s pull", quote("sh", config))
+	}
+	switch policy {
+	case "never":
+		return ""
+	case "always":
+		return fmt.Sprintf("%s %s", pull, image)
+	default:
+		return fmt.Sprintf("docker image inspect %s > /dev/null 2>&1 || %s %s", image, pull, image)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func Test_pullScript(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{policy: "", want: "docker image inspect 'golang:1.14' > /dev/null 2>&1 || docker pull 'golang:1.14'"},
+		{policy: "if-not-exists", want: "docker image inspect 'golang:1.14' > /dev/null 2>&1 || docker pull 'golang:1.14'"},
+		{policy: "always", want: "docker pull 'golang:1.14'"},
+		{policy: "never", want: ""},
+	}
+	for _, test := range tests {
+		if got := pullScript(test.policy, "golang:1.14", ""); got != test.want {
+			t.Errorf("Want %q for policy %q, got %q", test.want, test.policy, got)
+		}
+	}
+}
+
+func Test_pullScript_Config(t *testing.T) {
+	got := pullScript("always", "golang:1.14", "/tmp/drone-random/home/drone/.docker")
+	want := "docker --config '/tmp/drone-random/home/drone/.docker' pull 'golang:1.14'"
+	if got != want {
+		t.Errorf("Want %q, got %q", want, got)
+	}
+}
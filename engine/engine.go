@@ -5,10 +5,23 @@
 package engine
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
+	"time"
 
+	"github.com/drone-runners/drone-runner-aws/internal/metrics"
+	"github.com/drone-runners/drone-runner-aws/internal/platform"
+	"github.com/drone-runners/drone-runner-aws/internal/sshkey"
+	"github.com/drone-runners/drone-runner-aws/internal/userdata"
+
+	"github.com/drone/runner-go/logger"
 	"github.com/drone/runner-go/pipeline/runtime"
+
+	"github.com/dchest/uniuri"
 )
 
 // Opts configures the Engine.
@@ -16,6 +29,26 @@ type Opts struct {
 	// TODO replace or remove
 	Param1 string
 	Param2 string
+
+	// CacheEndpoint overrides the S3 endpoint used to save
+	// and restore the pipeline cache, and is used to connect
+	// to an S3-compatible server.
+	CacheEndpoint string
+
+	// ArtifactsDir is the local directory to which the
+	// pipeline artifacts are copied.
+	ArtifactsDir string
+
+	// ArtifactsBucket, ArtifactsRegion and ArtifactsEndpoint
+	// configure the S3 bucket to which the pipeline artifacts
+	// are uploaded.
+	ArtifactsBucket   string
+	ArtifactsRegion   string
+	ArtifactsEndpoint string
+
+	// Metrics records the instance lifecycle and step
+	// execution metrics. If nil, metrics are not recorded.
+	Metrics metrics.Metrics
 }
 
 // Engine implements a pipeline engine.
@@ -23,6 +56,25 @@ type Engine struct {
 	// TODO replace or remove
 	Param1 string
 	Param2 string
+
+	// CacheEndpoint overrides the S3 endpoint used to save
+	// and restore the pipeline cache.
+	CacheEndpoint string
+
+	// ArtifactsDir is the local directory to which the
+	// pipeline artifacts are copied.
+	ArtifactsDir string
+
+	// ArtifactsBucket, ArtifactsRegion and ArtifactsEndpoint
+	// configure the S3 bucket to which the pipeline artifacts
+	// are uploaded.
+	ArtifactsBucket   string
+	ArtifactsRegion   string
+	ArtifactsEndpoint string
+
+	// Metrics records the instance lifecycle and step
+	// execution metrics. If nil, metrics are not recorded.
+	Metrics metrics.Metrics
 }
 
 // New returns a new engine.
@@ -31,46 +83,324 @@ func New(opts Opts) (*Engine, error) {
 		// TODO replace or remove
 		Param1: opts.Param1,
 		Param2: opts.Param2,
+
+		CacheEndpoint:     opts.CacheEndpoint,
+		ArtifactsDir:      opts.ArtifactsDir,
+		ArtifactsBucket:   opts.ArtifactsBucket,
+		ArtifactsRegion:   opts.ArtifactsRegion,
+		ArtifactsEndpoint: opts.ArtifactsEndpoint,
+		Metrics:           opts.Metrics,
 	}, nil
 }
 
 // Setup the pipeline environment.
 func (e *Engine) Setup(ctx context.Context, specv runtime.Spec) error {
-	// spec := specv.(*Spec)
+	spec := specv.(*Spec)
+	ctx = e.withMetrics(ctx)
+	if spec.Error != "" {
+		return errors.New(spec.Error)
+	}
+
+	// the pipeline timeout is measured from the start of the
+	// pipeline, and includes the time required to provision
+	// the instance.
+	if spec.Timeout > 0 {
+		spec.deadline = time.Now().Add(spec.Timeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, spec.deadline)
+		defer cancel()
+	}
+
+	// generate a temporary key pair used to access the
+	// instance for the duration of the pipeline.
+	public, private, err := sshkey.GeneratePair()
+	if err != nil {
+		return err
+	}
+	spec.privateKey = private
+
+	// the userdata script authorizes the public key and, on
+	// windows, configures the server used to access the
+	// instance. the winrm transport authenticates with a
+	// generated password instead of the public key.
+	params := userdata.Params{
+		PublicKey: trimPublicKey(public),
+		User:      spec.Instance.User,
+	}
+	var script string
+	switch {
+	case spec.Platform.OS == "windows" && spec.Instance.Transport == "winrm":
+		spec.password = generatePassword()
+		params.Password = spec.password
+		script = userdata.WinRM(params)
+	case spec.Platform.OS == "windows":
+		script = userdata.Windows(params)
+	default:
+		script = userdata.Linux(params)
+	}
+
+	// merge the custom userdata defined in the pipeline or
+	// provided by the runner with the generated userdata.
+	script, err = userdata.Merge(spec.Platform.OS, script, spec.Instance.Userdata)
+	if err != nil {
+		return err
+	}
+
+	creds := platform.Credentials{
+		Client:     spec.Account.AccessKeyID,
+		Secret:     spec.Account.AccessKeySecret,
+		RoleARN:    spec.Account.RoleARN,
+		ExternalID: spec.Account.ExternalID,
+		Region:     spec.Account.Region,
+		Default:    spec.Account.Default,
+	}
+	args := platform.ProvisionArgs{
+		Image:      spec.Instance.AMI,
+		Name:       fmt.Sprintf("drone-%s", uniuri.NewLen(8)),
+		Region:     spec.Account.Region,
+		Size:       spec.Instance.Type,
+		Subnet:     spec.Instance.Network.SubnetID,
+		Groups:     spec.Instance.Network.VPCSecurityGroups,
+		Device:     spec.Instance.Device.Name,
+		PrivateIP:  spec.Instance.Network.PrivateIP,
+		VolumeType: spec.Instance.Disk.Type,
+		VolumeSize: spec.Instance.Disk.Size,
+		VolumeIops: spec.Instance.Disk.Iops,
+		Userdata:   script,
+
+		IamProfileArn: spec.Instance.IAMProfileARN,
+	}
+
+	// the instance is stored in the spec even if creation
+	// fails, so that a partially provisioned instance is
+	// still terminated when the pipeline is destroyed.
+	instance, err := platform.Create(ctx, creds, args)
+	spec.instance = instance
+	if err != nil {
+		return err
+	}
+
+	// the instance details are exposed to the pipeline steps
+	// as environment variables.
+	spec.Runtime = convertRuntime(instance)
+	injectRuntime(spec)
 
-	// TODO setup any resources for this pipeline to execute.
-	// For example, the digitalocean runner creates a virtual
-	// machine here.
+	// establish a connection with the instance. the
+	// instance may still be booting so we retry until the
+	// connection succeeds or times out.
+	client, err := dial(ctx, spec, true)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
 
+	e.metrics().InstanceReady(instance.Type, instance.Region, time.Since(instance.Launched))
+
+	// the pipeline workspace is created before pipeline
+	// execution begins. All files and folders created during
+	// pipeline execution are isolated to this workspace.
+	for _, file := range spec.Files {
+		if file.IsDir {
+			err = client.Mkdir(ctx, file.Path, file.Mode)
+		} else {
+			err = client.Upload(ctx, file.Path, file.Data, file.Mode)
+		}
+		if err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				WithField("path", file.Path).
+				Error("cannot create workspace file")
+			return err
+		}
+	}
 	return nil
 }
 
 // Destroy the pipeline environment.
 func (e *Engine) Destroy(ctx context.Context, specv runtime.Spec) error {
-	// spec := specv.(*Spec)
+	spec := specv.(*Spec)
+	if spec.instance == nil {
+		return nil
+	}
 
-	// TODO teardown any resources created for this pipeline.
-	// For example, the digitalocean runner terminates the
-	// virtual machiune here.
+	// detached steps and services are stopped when the pipeline
+	// context is cancelled. wait for them to exit before the
+	// instance is terminated.
+	if !waitDetached(spec, time.Minute) {
+		logger.FromContext(ctx).
+			WithField("instance", spec.instance.ID).
+			Warn("timeout waiting for detached steps to exit")
+	}
 
-	return nil
+	creds := platform.Credentials{
+		Client:     spec.Account.AccessKeyID,
+		Secret:     spec.Account.AccessKeySecret,
+		RoleARN:    spec.Account.RoleARN,
+		ExternalID: spec.Account.ExternalID,
+		Region:     spec.Account.Region,
+		Default:    spec.Account.Default,
+	}
+	return platform.Destroy(e.withMetrics(ctx), creds, spec.instance)
 }
 
 // Run runs the pipeline step.
 func (e *Engine) Run(ctx context.Context, specv runtime.Spec, stepv runtime.Step, output io.Writer) (*runtime.State, error) {
-	// spec := specv.(*Spec)
-	// step := stepv.(*Step)
+	start := time.Now()
+	state, err := e.run(ctx, specv, stepv, output)
+
+	// detached steps run for the duration of the pipeline and
+	// are not included in the step duration metrics.
+	if !stepv.(*Step).Detach {
+		status := "success"
+		switch {
+		case err != nil:
+			status = "error"
+		case state.ExitCode != 0:
+			status = "failure"
+		}
+		e.metrics().StepCompleted(status, time.Since(start))
+	}
+	return state, err
+}
+
+func (e *Engine) run(ctx context.Context, specv runtime.Spec, stepv runtime.Step, output io.Writer) (*runtime.State, error) {
+	spec := specv.(*Spec)
+	step := stepv.(*Step)
+
+	// detached steps and services are tracked so that the
+	// instance is not terminated until they exit.
+	if step.Detach {
+		spec.detached.Add(1)
+		defer spec.detached.Done()
+	}
+
+	// the step and pipeline timeouts are enforced by cancelling
+	// the context, which kills the remote process.
+	parent := ctx
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, step.Timeout)
+		defer cancel()
+	}
+	if !spec.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, spec.deadline)
+		defer cancel()
+	}
+
+	client, err := dial(ctx, spec, false)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	// cache steps restore or save the cache archive, and do
+	// not execute a command on the instance.
+	if step.Cache != nil {
+		state, err := e.runCache(ctx, spec, step, client, output)
+		if err != nil {
+			return nil, checkTimeout(parent, spec, step, err)
+		}
+		return state, nil
+	}
+
+	// the artifacts step copies the artifacts from the
+	// instance before the instance is destroyed.
+	if len(step.Artifacts) != 0 {
+		state, err := e.runArtifacts(ctx, spec, step, client, output)
+		if err != nil {
+			return nil, checkTimeout(parent, spec, step, err)
+		}
+		return state, nil
+	}
 
-	// TODO execute the pipeline step
-	// TODO write the pipeline step output to the io.Writer
-	// TODO return the pipeline step results
+	// the execer discards the state of the detached steps,
+	// so a detached step that crashed is reported by the
+	// steps that depend on it.
+	if err := checkDetached(spec, step); err != nil {
+		return nil, err
+	}
+
+	// steps wait for the pipeline services to pass the
+	// readiness check before execution begins.
+	if !step.Detach {
+		if err := waitServices(ctx, spec, client); err != nil {
+			return nil, checkTimeout(parent, spec, step, err)
+		}
+	}
+
+	// unlike os/exec there is no good way to set environment
+	// the working directory or configure environment variables.
+	// we work around this by pre-pending these configurations
+	// to the pipeline execution script.
+	shell := getShell(spec.Platform.OS, step)
+	if err := checkEnv(shell, step); err != nil {
+		return nil, err
+	}
+	for _, file := range step.Files {
+		w := new(bytes.Buffer)
+		writeHeader(w, shell)
+		writeWorkdir(w, shell, step.WorkingDir)
+		writeSecrets(w, shell, step.Secrets)
+		writeEnviron(w, shell, step.Envs)
+		w.Write(file.Data)
+		if err := client.Upload(ctx, file.Path, w.Bytes(), file.Mode); err != nil {
+			return nil, err
+		}
+	}
+
+	// image steps pull the image, according to the pull
+	// policy, before the container is started.
+	if step.Image != "" {
+		if err := pullImage(ctx, client, step, output); err != nil {
+			return nil, checkTimeout(parent, spec, step, err)
+		}
+	}
+
+	cmd := step.Command + " " + strings.Join(step.Args, " ")
+	if step.Detach {
+		state, err := runDetached(ctx, spec, step, client, cmd, output)
+		if err != nil {
+			if step.Image != "" {
+				removeContainer(ctx, client, step)
+			}
+			return nil, checkTimeout(parent, spec, step, err)
+		}
+		return state, nil
+	}
+	code, err := client.Exec(ctx, cmd, output)
+	if err != nil {
+		if step.Image != "" {
+			removeContainer(ctx, client, step)
+		}
+		return nil, checkTimeout(parent, spec, step, err)
+	}
+
+	logger.FromContext(ctx).
+		WithField("exit", code).
+		Debug("step finished")
 
 	return &runtime.State{
-		ExitCode: 0,
+		ExitCode: code,
 		Exited:   true,
 	}, nil
 }
 
+// helper function returns the engine metrics, or the no-op
+// metrics if not configured.
+func (e *Engine) metrics() metrics.Metrics {
+	if e.Metrics == nil {
+		return metrics.Nop()
+	}
+	return e.Metrics
+}
+
+// helper function returns a new context with the engine
+// metrics, used to record the instance lifecycle metrics.
+func (e *Engine) withMetrics(ctx context.Context) context.Context {
+	return metrics.WithContext(ctx, e.metrics())
+}
+
 // Ping pings the underlying runtime to verify connectivity.
 func (e *Engine) Ping(ctx context.Context) error {
 	// TODO optionally add code to ping the underlying
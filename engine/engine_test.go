@@ -0,0 +1,497 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/platform"
+	"github.com/drone-runners/drone-runner-aws/internal/sshkey"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// This test verifies the pipeline fails before an instance
+// is provisioned when the spec has a compiler error.
+func TestSetup_Error(t *testing.T) {
+	spec := &Spec{Error: "unknown account production"}
+	err := new(Engine).Setup(context.Background(), spec)
+	if err == nil || err.Error() != "unknown account production" {
+		t.Errorf("Want compiler error, got %v", err)
+	}
+	if spec.instance != nil {
+		t.Errorf("Want no instance provisioned")
+	}
+}
+
+// This test verifies the step timeout kills the remote
+// process, and its children, and fails the step with a
+// timeout-specific error.
+func TestRun_StepTimeout(t *testing.T) {
+	spec, dir, closer := testServer(t)
+	defer closer()
+	step := testStep(dir)
+	step.Timeout = time.Second
+
+	state, err := new(Engine).Run(context.Background(), spec, step, ioutil.Discard)
+	if state != nil {
+		t.Errorf("Expect nil state when the step times out")
+	}
+	if err == nil || err.Error() != "step exceeded the 1s timeout" {
+		t.Errorf("Want step timeout error, got %v", err)
+	}
+	testKilled(t, dir)
+}
+
+// This test verifies the pipeline timeout kills the remote
+// process, and its children, and fails the step with a
+// timeout-specific error.
+func TestRun_PipelineTimeout(t *testing.T) {
+	spec, dir, closer := testServer(t)
+	defer closer()
+	spec.Timeout = time.Second
+	spec.deadline = time.Now().Add(spec.Timeout)
+	step := testStep(dir)
+	step.Timeout = time.Hour
+
+	_, err := new(Engine).Run(context.Background(), spec, step, ioutil.Discard)
+	if err == nil || err.Error() != "pipeline exceeded the 1s timeout" {
+		t.Errorf("Want pipeline timeout error, got %v", err)
+	}
+	testKilled(t, dir)
+}
+
+// This test verifies the step completes and returns the exit
+// code when the timeout is not exceeded.
+func TestRun_NoTimeout(t *testing.T) {
+	spec, dir, closer := testServer(t)
+	defer closer()
+	step := testStep(dir)
+	step.Timeout = time.Minute
+	step.Files[0].Data = []byte("echo hello\nexit 3\n")
+
+	buf := new(bytes.Buffer)
+	state, err := new(Engine).Run(context.Background(), spec, step, buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := state.ExitCode, 3; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+	if got, want := buf.String(), "hello\n"; got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+}
+
+// This test verifies the step waits for the service to accept
+// connections on the readiness port.
+func TestRun_ServiceReady(t *testing.T) {
+	spec, dir, closer := testServer(t)
+	defer closer()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	spec.Steps = []*Step{
+		{Name: "redis", Detach: true, Ready: &Ready{Port: port, Timeout: time.Minute}},
+		{Name: "test", Detach: true, Ready: &Ready{Command: "true", Timeout: time.Minute}},
+	}
+	step := testStep(dir)
+	step.Files[0].Data = []byte("exit 0\n")
+
+	state, err := new(Engine).Run(context.Background(), spec, step, ioutil.Discard)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if state.ExitCode != 0 {
+		t.Errorf("Want exit code 0, got %d", state.ExitCode)
+	}
+	if !isReady(spec, "redis") || !isReady(spec, "test") {
+		t.Errorf("Expect services ready")
+	}
+}
+
+// This test verifies the step fails with a service-specific
+// error if the service does not pass the readiness check.
+func TestRun_ServiceNotReady(t *testing.T) {
+	spec, dir, closer := testServer(t)
+	defer closer()
+
+	spec.Steps = []*Step{
+		{Name: "redis", Detach: true, Ready: &Ready{Command: "exit 1", Timeout: time.Second}},
+	}
+	step := testStep(dir)
+	step.Files[0].Data = []byte("exit 0\n")
+
+	_, err := new(Engine).Run(context.Background(), spec, step, ioutil.Discard)
+	if err == nil || err.Error() != "service redis is not ready after 1s" {
+		t.Errorf("Want service readiness error, got %v", err)
+	}
+}
+
+// This test verifies the detached step runs in the background,
+// the output is copied to the step log, and the step, and its
+// children, are stopped when the pipeline context is cancelled.
+func TestRun_Detached(t *testing.T) {
+	spec, dir, closer := testServer(t)
+	defer closer()
+	step := testStep(dir)
+	step.Detach = true
+	step.Files[0].Data = append([]byte("echo hello\n"), step.Files[0].Data...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	output := new(syncBuffer)
+	done := make(chan error)
+	go func() {
+		_, err := new(Engine).Run(ctx, spec, step, output)
+		done <- err
+	}()
+
+	for i := 0; !strings.Contains(output.String(), "hello"); i++ {
+		if i == 100 {
+			t.Fatalf("Want detached step output, got %q", output.String())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Want context cancelled error, got %v", err)
+	}
+	testKilled(t, dir)
+}
+
+// This test verifies the exit code of the detached step is
+// recorded when the step exits.
+func TestRun_DetachedExit(t *testing.T) {
+	spec, dir, closer := testServer(t)
+	defer closer()
+	step := testStep(dir)
+	step.Detach = true
+	step.Files[0].Data = []byte("echo crash\nexit 3\n")
+
+	output := new(syncBuffer)
+	state, err := new(Engine).Run(context.Background(), spec, step, output)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := state.ExitCode, 3; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+	if got, want := output.String(), "crash\ndetached step exited with code 3\n"; got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+	if code, ok := exitCode(spec, step.Name); !ok || code != 3 {
+		t.Errorf("Expect exit code recorded")
+	}
+}
+
+// This test verifies the step fails, naming the detached step,
+// if a detached step it depends on exited with a non-zero exit
+// code before the step runs.
+func TestRun_DetachedCrash(t *testing.T) {
+	spec, dir, closer := testServer(t)
+	defer closer()
+
+	detached := testStep(dir)
+	detached.Name = "server"
+	detached.Detach = true
+	detached.Files[0].Data = []byte("echo crash\nexit 3\n")
+	spec.Steps = []*Step{detached}
+
+	if _, err := new(Engine).Run(context.Background(), spec, detached, ioutil.Discard); err != nil {
+		t.Error(err)
+		return
+	}
+
+	step := testStep(dir)
+	step.DependsOn = []string{"server"}
+	step.Files[0].Data = []byte("echo hello\n")
+	output := new(syncBuffer)
+	_, err := new(Engine).Run(context.Background(), spec, step, output)
+	if err == nil || err.Error() != "detached step server exited with code 3" {
+		t.Errorf("Want detached step crash error, got %v", err)
+	}
+	if strings.Contains(output.String(), "hello") {
+		t.Errorf("Want dependent step not executed")
+	}
+}
+
+// This test verifies the step fails if the service exited
+// before it passed the readiness check.
+func TestRun_ServiceExited(t *testing.T) {
+	spec, dir, closer := testServer(t)
+	defer closer()
+
+	spec.Steps = []*Step{
+		{Name: "redis", Detach: true, Ready: &Ready{Command: "exit 1", Timeout: time.Minute}},
+	}
+	setExited(spec, "redis", 1)
+	step := testStep(dir)
+
+	_, err := new(Engine).Run(context.Background(), spec, step, ioutil.Discard)
+	if err == nil || err.Error() != "service redis exited with code 1" {
+		t.Errorf("Want service exited error, got %v", err)
+	}
+}
+
+// helper function returns a step that records the process id
+// of the step and a child process, and then blocks.
+func testStep(dir string) *Step {
+	script := strings.Join([]string{
+		"echo $$ > " + filepath.Join(dir, "step.pid"),
+		"sleep 300 &",
+		"echo $! > " + filepath.Join(dir, "child.pid"),
+		"wait",
+	}, "\n")
+	path := filepath.Join(dir, "step")
+	return &Step{
+		Name:    "test",
+		Command: "/bin/sh",
+		Args:    []string{"-e", path},
+		Shell:   "sh",
+		Files: []*File{
+			{Path: path, Mode: 0700, Data: []byte(script)},
+		},
+	}
+}
+
+// helper function verifies the step process and its child
+// process are no longer running.
+func testKilled(t *testing.T, dir string) {
+	for _, name := range []string{"step.pid", "child.pid"} {
+		raw, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(raw)))
+		for i := 0; running(pid); i++ {
+			if i == 50 {
+				t.Errorf("Expect process %s %d killed", name, pid)
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
+// helper function returns true if the process is running.
+// zombie processes are not running, since the container init
+// process may not reap orphaned children.
+func running(pid int) bool {
+	raw, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	stat := string(raw)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	return len(fields) != 0 && fields[0] != "Z"
+}
+
+// syncBuffer is a buffer that is safe for concurrent use.
+type syncBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+// helper function starts a local ssh server and returns a spec
+// configured to connect to the server, and a temporary
+// directory for the pipeline files. The server behaves like an
+// openssh server prior to version 7.9, which ignores signals.
+func testServer(t *testing.T) (*Spec, string, func()) {
+	dir, err := ioutil.TempDir("", "drone-engine-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, private, err := sshkey.GeneratePair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, _, _, _, err := ssh.ParseAuthorizedKey([]byte(public))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostkey, err := ssh.ParsePrivateKey([]byte(private))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostkey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, config)
+		}
+	}()
+
+	spec := &Spec{
+		Root:     dir,
+		Platform: Platform{OS: "linux"},
+		Instance: Instance{User: "root", Transport: "ssh"},
+		instance: &platform.Instance{
+			IP: listener.Addr().String(),
+		},
+		privateKey: private,
+	}
+	closer := func() {
+		listener.Close()
+		os.RemoveAll(dir)
+	}
+	return spec, dir, closer
+}
+
+func serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() == "direct-tcpip" {
+			go serveForward(newChan)
+			continue
+		}
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(channel, requests)
+	}
+}
+
+// helper function forwards the channel to the local address,
+// in the same way as the openssh server.
+func serveForward(newChan ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	ssh.Unmarshal(newChan.ExtraData(), &payload)
+	conn, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChan.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
+	}()
+	io.Copy(channel, conn)
+	channel.Close()
+}
+
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	for req := range requests {
+		switch req.Type {
+		case "subsystem":
+			req.Reply(true, nil)
+			go func() {
+				server, _ := sftp.NewServer(channel)
+				server.Serve()
+				channel.Close()
+			}()
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(req.Payload, &payload)
+			req.Reply(true, nil)
+			go serveExec(channel, payload.Command)
+		default:
+			// signals are ignored, similar to openssh
+			// servers prior to version 7.9.
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// helper function executes the command in a new session, in
+// the same way as the openssh server, and sends the exit
+// status to the client.
+func serveExec(channel ssh.Channel, command string) {
+	defer channel.Close()
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stdout = channel
+	cmd.Stderr = channel.Stderr()
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	code := 0
+	if err := cmd.Run(); err != nil {
+		code = 255
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			code = exiterr.ExitCode()
+		}
+	}
+	status := make([]byte, 4)
+	binary.BigEndian.PutUint32(status, uint32(code))
+	channel.SendRequest("exit-status", false, status)
+}
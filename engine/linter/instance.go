@@ -0,0 +1,113 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package linter
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/drone-runners/drone-runner-aws/engine/resource"
+	"github.com/drone-runners/drone-runner-aws/internal/catalog"
+)
+
+// diskRange defines the minimum and maximum disk size, in GiB,
+// for each supported disk type.
+var diskRange = map[string][2]int64{
+	"standard": {1, 1024},
+	"gp2":      {1, 16384},
+	"gp3":      {1, 16384},
+	"io1":      {4, 16384},
+	"io2":      {4, 16384},
+	"st1":      {125, 16384},
+	"sc1":      {125, 16384},
+}
+
+// iopsRange defines the minimum and maximum provisioned iops,
+// and the maximum ratio of provisioned iops to the disk size,
+// for each disk type that supports provisioned iops.
+var iopsRange = map[string][3]int64{
+	"io1": {100, 64000, 50},
+	"io2": {100, 64000, 500},
+	"gp3": {3000, 16000, 500},
+}
+
+// valid device names, for example /dev/sda1 or /dev/xvda.
+var deviceName = regexp.MustCompile(`^(/dev/)?(sd[a-z][1-9]?|xvd[a-z][a-z]?)$`)
+
+// checkInstance verifies the instance type, architecture, disk
+// and device settings using the embedded instance type catalog.
+func checkInstance(pipeline *resource.Pipeline) []error {
+	var errs []error
+	if err := checkInstanceType(pipeline); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, checkDisk(pipeline.Instance.Disk)...)
+	if name := pipeline.Instance.Device.Name; name != "" && !deviceName.MatchString(name) {
+		errs = append(errs, fmt.Errorf("Linter: invalid device name %s", name))
+	}
+	return errs
+}
+
+func checkInstanceType(pipeline *resource.Pipeline) error {
+	name := pipeline.Instance.Type
+	if name == "" {
+		return nil
+	}
+	t, ok := catalog.Lookup(name)
+	if !ok {
+		// instance families missing from the catalog are not
+		// verified, since the catalog may predate the family.
+		if catalog.HasFamily(catalog.Family(name)) {
+			return fmt.Errorf("Linter: unknown instance type %s", name)
+		}
+		return nil
+	}
+	if arch := pipeline.Platform.Arch; !t.SupportsArch(arch) {
+		if arch == "" {
+			arch = "amd64"
+		}
+		return fmt.Errorf("Linter: instance type %s does not support the %s architecture", name, arch)
+	}
+	return nil
+}
+
+func checkDisk(disk resource.Disk) []error {
+	var errs []error
+	kind := disk.Type
+	if kind == "" {
+		kind = "gp2"
+	}
+	size := disk.Size
+	if size == 0 {
+		size = 32
+	}
+	limits, ok := diskRange[kind]
+	if !ok {
+		return append(errs, fmt.Errorf("Linter: invalid disk type %s", kind))
+	}
+	if size < limits[0] || size > limits[1] {
+		errs = append(errs, fmt.Errorf("Linter: disk size %d is out of range for the %s disk type (%d-%d GiB)", size, kind, limits[0], limits[1]))
+	}
+	if disk.Iops == 0 {
+		return errs
+	}
+	iops, ok := iopsRange[kind]
+	if !ok {
+		return append(errs, fmt.Errorf("Linter: disk iops are not supported by the %s disk type", kind))
+	}
+	max := iops[1]
+	if size*iops[2] < max {
+		max = size * iops[2]
+	}
+	// gp3 disks provide the baseline iops regardless of the
+	// disk size.
+	if max < iops[0] {
+		max = iops[0]
+	}
+	if disk.Iops < iops[0] || disk.Iops > max {
+		errs = append(errs, fmt.Errorf("Linter: disk iops %d is out of range for a %d GiB %s disk (%d-%d)", disk.Iops, size, kind, iops[0], max))
+	}
+	return errs
+}
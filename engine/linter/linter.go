@@ -6,16 +6,62 @@ package linter
 
 import (
 	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
 
 	"github.com/drone-runners/drone-runner-aws/engine/resource"
+	"github.com/drone-runners/drone-runner-aws/internal/account"
+	"github.com/drone-runners/drone-runner-aws/internal/pool"
+	"github.com/drone-runners/drone-runner-aws/internal/userdata"
 	"github.com/drone/drone-go/drone"
 	"github.com/drone/runner-go/manifest"
 )
 
+// userPattern matches the valid instance user names.
+var userPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
 // Linter evaluates the pipeline against a set of
 // rules and returns an error if one or more of the
 // rules are broken.
-type Linter struct{}
+type Linter struct {
+	// ImageTags returns the tags of the AMI in the region.
+	// If defined, it is used to verify the AMI is tagged as
+	// docker-capable when the pipeline does not declare it.
+	ImageTags func(region, ami string) (map[string]string, error)
+
+	// Allow defines the sensitive instance settings that
+	// untrusted repositories are allowed to use.
+	Allow Allowlist
+
+	// RequirePrivateIP requires untrusted repositories to
+	// provision instances without a public ip address.
+	RequirePrivateIP bool
+
+	// Pools provides the named instance pools that pipelines
+	// can reference.
+	Pools pool.Pools
+
+	// Accounts provides the named accounts that pipelines
+	// can reference, if allowed for the repository. If
+	// accounts are configured, pipelines that do not use a
+	// pool must reference a named account.
+	Accounts account.Accounts
+
+	// Userdata provides the default custom userdata used
+	// when the pipeline does not define userdata.
+	Userdata string
+
+	// DefaultCredentials allows pipelines that do not define
+	// account credentials to use the default credential chain
+	// of the runner.
+	DefaultCredentials bool
+}
+
+// DockerTag is the AMI tag that declares the AMI has docker
+// installed.
+const DockerTag = "drone:docker"
 
 // New returns a new Linter.
 func New() *Linter {
@@ -25,32 +71,530 @@ func New() *Linter {
 // Lint executes the linting rules for the pipeline
 // configuration.
 func (l *Linter) Lint(pipeline manifest.Resource, repo *drone.Repo) error {
-	return checkPipeline(pipeline.(*resource.Pipeline), repo.Trusted)
+	source := pipeline.(*resource.Pipeline)
+	merged, errs := l.checkPool(source)
+	if merged == nil {
+		return errs.err()
+	}
+	errs = append(errs, checkPipeline(merged, repo.Trusted)...)
+	if err := checkUserdata(merged, l.Userdata); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, l.checkAccount(merged, repo)...)
+	if err := l.checkCredentials(merged); err != nil {
+		errs = append(errs, err)
+	}
+	// the trust rules are evaluated against the settings
+	// defined by the pipeline, since the pool settings are
+	// defined by the runner.
+	errs = append(errs, l.checkTrust(source, repo.Trusted)...)
+	if err := errs.err(); err != nil {
+		return err
+	}
+	return l.checkDocker(merged)
 }
 
-func checkPipeline(pipeline *resource.Pipeline, trusted bool) error {
-	if err := checkSteps(pipeline, trusted); err != nil {
-		return err
+// checkCredentials verifies the pipeline defines the account
+// credentials, or references a named account or pool, unless
+// the runner allows the default credential chain.
+func (l *Linter) checkCredentials(pipeline *resource.Pipeline) error {
+	switch {
+	case l.DefaultCredentials:
+		return nil
+	case pipeline.Account.Name != "", pipeline.Pool != "":
+		return nil
+	case pipeline.Account.AccessKeyID != (manifest.Variable{}):
+		return nil
+	default:
+		return errors.New("Linter: missing account credentials")
+	}
+}
+
+// checkAccount verifies the named account exists and the
+// repository is allowed to use the account.
+func (l *Linter) checkAccount(pipeline *resource.Pipeline, repo *drone.Repo) []error {
+	name := pipeline.Account.Name
+	if name == "" {
+		if len(l.Accounts) != 0 && pipeline.Pool == "" {
+			return []error{errors.New("Linter: pipeline must use a named account")}
+		}
+		return nil
+	}
+	a, ok := l.Accounts.Lookup(name)
+	if !ok {
+		return []error{fmt.Errorf("Linter: unknown account %s", name)}
+	}
+	var errs []error
+	creds := pipeline.Account
+	if creds.AccessKeyID != (manifest.Variable{}) || creds.AccessKeySecret != (manifest.Variable{}) {
+		errs = append(errs, fmt.Errorf("Linter: account %s cannot be combined with access keys", name))
+	}
+	if !a.Allowed(repo.Slug) {
+		errs = append(errs, fmt.Errorf("Linter: repository %s is not allowed to use account %s", repo.Slug, name))
+	}
+	return errs
+}
+
+// checkPool verifies the named pool exists and the pipeline
+// does not override the pool settings, and returns the
+// pipeline merged with the pool settings. If the pool does
+// not exist, a nil pipeline is returned.
+func (l *Linter) checkPool(pipeline *resource.Pipeline) (*resource.Pipeline, Errors) {
+	if pipeline.Pool == "" {
+		return pipeline, nil
+	}
+	p, ok := l.Pools.Lookup(pipeline.Pool)
+	if !ok {
+		return nil, Errors{fmt.Errorf("Linter: unknown pool %s", pipeline.Pool)}
+	}
+	var errs Errors
+	for _, name := range pool.Overrides(pipeline) {
+		errs = append(errs, fmt.Errorf("Linter: pipeline using pool %s cannot override %s", pipeline.Pool, name))
 	}
+	return p.Apply(pipeline), errs
+}
+
+func checkPipeline(pipeline *resource.Pipeline, trusted bool) []error {
+	// the pipeline rules are evaluated together, so that all
+	// broken rules are reported at once.
+	var errs []error
+	errs = append(errs, checkSteps(pipeline, trusted)...)
+	errs = append(errs, checkDeps(pipeline)...)
 	if pipeline.Instance.AMI == "" {
-		return errors.New("Linter: invalid or missing AMI")
+		errs = append(errs, errors.New("Linter: invalid or missing AMI"))
 	}
-	return nil
+	errs = append(errs, checkInstance(pipeline)...)
+	if err := checkTransport(pipeline); err != nil {
+		errs = append(errs, err)
+	}
+	if !isUser(pipeline.Instance.User) {
+		errs = append(errs, errors.New("Linter: invalid instance user name"))
+	}
+	if escapesRoot("", pipeline.Workspace.Path) {
+		errs = append(errs, errors.New("Linter: workspace path cannot escape the root directory"))
+	}
+	errs = append(errs, checkCache(pipeline)...)
+	errs = append(errs, checkArtifacts(pipeline)...)
+	return errs
+}
+
+func checkArtifacts(pipeline *resource.Pipeline) []error {
+	var errs []error
+	workspace := pipeline.Workspace.Path
+	if workspace == "" {
+		workspace = "drone/src"
+	}
+	for _, p := range pipeline.Artifacts {
+		// artifact paths are expanded by the remote shell,
+		// and cannot include shell metacharacters other than
+		// the glob metacharacters.
+		if p == "" || strings.ContainsAny(p, ";&|$`<>()'\"\n") {
+			errs = append(errs, fmt.Errorf("Linter: invalid artifact path %q", p))
+		} else if escapesRoot(workspace, p) {
+			errs = append(errs, fmt.Errorf("Linter: artifact path %q cannot escape the root directory", p))
+		}
+	}
+	return errs
+}
+
+func checkCache(pipeline *resource.Pipeline) []error {
+	var errs []error
+	cache := pipeline.Cache
+	if len(cache.Paths) == 0 {
+		if cache.Bucket != "" || cache.Key != "" {
+			errs = append(errs, errors.New("Linter: cache requires at least one path"))
+		}
+		return errs
+	}
+	if cache.Bucket == "" {
+		errs = append(errs, errors.New("Linter: cache requires a bucket"))
+	}
+	if cache.Key == "" {
+		errs = append(errs, errors.New("Linter: cache requires a key"))
+	}
+	workspace := pipeline.Workspace.Path
+	if workspace == "" {
+		workspace = "drone/src"
+	}
+	for _, p := range cache.Paths {
+		if p == "" || escapesRoot(workspace, p) {
+			errs = append(errs, fmt.Errorf("Linter: cache path %q cannot escape the root directory", p))
+		}
+	}
+	return errs
 }
 
-func checkSteps(pipeline *resource.Pipeline, trusted bool) error {
+// checkDocker verifies the AMI is docker-capable if the
+// pipeline defines steps or services that run in a container.
+func (l *Linter) checkDocker(pipeline *resource.Pipeline) error {
+	var image bool
+	for _, service := range pipeline.Services {
+		image = image || service.Image != ""
+	}
 	for _, step := range pipeline.Steps {
-		if step == nil {
-			return errors.New("Linter: nil step")
+		image = image || step.Image != ""
+	}
+	if !image || pipeline.Instance.Docker {
+		return nil
+	}
+	if l.ImageTags != nil {
+		region := pipeline.Account.Region
+		if region == "" {
+			region = "us-east-1"
+		}
+		tags, err := l.ImageTags(region, pipeline.Instance.AMI)
+		if err != nil {
+			return fmt.Errorf("Linter: cannot verify the AMI is docker-capable: %s", err)
+		}
+		if tags[DockerTag] == "true" {
+			return nil
+		}
+	}
+	return errors.New("Linter: image steps require an AMI tagged or declared as docker-capable")
+}
+
+func checkTransport(pipeline *resource.Pipeline) error {
+	switch pipeline.Instance.Transport {
+	case "", "ssh":
+		return nil
+	case "winrm":
+		if pipeline.Platform.OS != "windows" {
+			return errors.New("Linter: the winrm transport requires the windows platform")
+		}
+		return nil
+	default:
+		return errors.New("Linter: invalid or unsupported transport")
+	}
+}
+
+// helper function returns true if the user name is empty, or
+// is a valid user name. The user name is written to the
+// generated userdata scripts.
+func isUser(name string) bool {
+	return name == "" || userPattern.MatchString(name)
+}
+
+// helper function verifies the userdata defined by the
+// pipeline, or the default userdata if not defined, can be
+// merged with the generated userdata.
+func checkUserdata(pipeline *resource.Pipeline, defaults string) error {
+	custom := pipeline.Instance.Userdata
+	if custom == "" {
+		custom = defaults
+	}
+	if custom == "" {
+		return nil
+	}
+	err := userdata.Check(
+		pipeline.Platform.OS,
+		pipeline.Instance.Transport,
+		pipeline.Instance.User,
+		custom,
+	)
+	switch err {
+	case nil:
+		return nil
+	case userdata.ErrFormat:
+		return errors.New("Linter: userdata must be a #cloud-config document or a #! script")
+	case userdata.ErrPowershell:
+		return errors.New("Linter: windows userdata must be a <powershell> script")
+	case userdata.ErrSize:
+		return errors.New("Linter: userdata exceeds the 16KB limit")
+	default:
+		return fmt.Errorf("Linter: invalid userdata: %s", err)
+	}
+}
+
+func checkSteps(pipeline *resource.Pipeline, trusted bool) []error {
+	var errs []error
+	for _, service := range pipeline.Services {
+		if service == nil {
+			errs = append(errs, errors.New("Linter: nil service"))
+			continue
 		}
-		if err := checkStep(step, trusted); err != nil {
-			return err
+		errs = append(errs, checkStep(pipeline, service, trusted)...)
+		if err := checkReady(service); err != nil {
+			errs = append(errs, lineError(service, err))
+		}
+	}
+	for _, step := range pipeline.Steps {
+		if step == nil {
+			errs = append(errs, errors.New("Linter: nil step"))
+			continue
 		}
+		errs = append(errs, checkStep(pipeline, step, trusted)...)
+	}
+	return errs
+}
+
+func checkReady(service *resource.Step) error {
+	if service.Ready.Port < 0 || service.Ready.Port > 65535 {
+		return fmt.Errorf("Linter: invalid readiness port for service %s", service.Name)
+	}
+	if service.Ready.Timeout < 0 {
+		return fmt.Errorf("Linter: invalid readiness timeout for service %s", service.Name)
 	}
 	return nil
 }
 
-func checkStep(step *resource.Step, trusted bool) error {
-	// define pipeline step linting rules here.
+func checkStep(pipeline *resource.Pipeline, step *resource.Step, trusted bool) []error {
+	var errs []error
+	// steps without an image execute the commands on the
+	// instance, and require at least one command. steps with
+	// settings but no image are reported by the image rules.
+	if step.Image == "" && len(step.Settings) == 0 && !hasCommands(step) {
+		errs = append(errs, fmt.Errorf("Linter: step %s requires at least one command", step.Name))
+	}
+	if err := checkShell(pipeline.Platform.OS, step.Shell); err != nil {
+		errs = append(errs, err)
+	}
+	workspace := pipeline.Workspace.Path
+	if workspace == "" {
+		workspace = "drone/src"
+	}
+	if step.WorkingDir != "" && escapesRoot(workspace, step.WorkingDir) {
+		errs = append(errs, errors.New("Linter: working_dir cannot escape the root directory"))
+	}
+	if err := checkImage(pipeline.Platform.OS, step); err != nil {
+		errs = append(errs, err)
+	}
+	for _, dep := range step.DependsOn {
+		if dep == step.Name {
+			errs = append(errs, fmt.Errorf("Linter: step %s cannot depend on itself", step.Name))
+		}
+	}
+	for i, err := range errs {
+		errs[i] = lineError(step, err)
+	}
+	return errs
+}
+
+// checkDeps verifies the step dependencies reference known
+// steps, and do not form a cycle or a deadlock.
+func checkDeps(pipeline *resource.Pipeline) []error {
+	var errs []error
+	steps := map[string]*resource.Step{}
+	services := map[string]bool{}
+	var all []*resource.Step
+	for _, service := range pipeline.Services {
+		if service != nil {
+			steps[service.Name] = service
+			services[service.Name] = true
+			all = append(all, service)
+		}
+	}
+	for _, step := range pipeline.Steps {
+		if step != nil {
+			steps[step.Name] = step
+			all = append(all, step)
+		}
+	}
+
+	for _, step := range all {
+		for _, dep := range step.DependsOn {
+			if dep == "clone" && !pipeline.Clone.Disable {
+				continue
+			}
+			if _, ok := steps[dep]; !ok {
+				errs = append(errs, lineError(step, fmt.Errorf("Linter: step %s depends on unknown step %s", step.Name, dep)))
+			}
+		}
+	}
+
+	// steps wait for the services to pass the readiness check
+	// before execution begins. a service that waits for a step
+	// to complete, directly or through a detached step, is
+	// never started.
+	for _, service := range all {
+		if !services[service.Name] {
+			continue
+		}
+		if name := findBlocking(steps, services, service); name != "" {
+			errs = append(errs, lineError(service, fmt.Errorf("Linter: service %s cannot depend on step %s, which waits for the services to be ready", service.Name, name)))
+		}
+	}
+
+	if cycle := findCycle(steps, all); len(cycle) != 0 {
+		errs = append(errs, lineError(steps[cycle[0]], fmt.Errorf("Linter: dependency cycle detected: %s", strings.Join(cycle, " -> "))))
+	}
+	return errs
+}
+
+// helper function returns the name of the first step that
+// the service depends on, directly or through detached steps
+// and other services, and that waits for the services to be
+// ready before execution begins.
+func findBlocking(steps map[string]*resource.Step, services map[string]bool, service *resource.Step) string {
+	visited := map[string]bool{}
+	var visit func(step *resource.Step) string
+	visit = func(step *resource.Step) string {
+		for _, dep := range step.DependsOn {
+			next, ok := steps[dep]
+			if !ok || visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			if !services[dep] && !next.Detach {
+				return dep
+			}
+			if name := visit(next); name != "" {
+				return name
+			}
+		}
+		return ""
+	}
+	return visit(service)
+}
+
+// helper function returns the first dependency cycle found in
+// the dependency graph, starting and ending with the same step.
+// Self-dependencies are reported by the step rules.
+func findCycle(steps map[string]*resource.Step, all []*resource.Step) []string {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var path []string
+	var visit func(step *resource.Step) []string
+	visit = func(step *resource.Step) []string {
+		state[step.Name] = visiting
+		path = append(path, step.Name)
+		for _, dep := range step.DependsOn {
+			next, ok := steps[dep]
+			if !ok || dep == step.Name {
+				continue
+			}
+			switch state[dep] {
+			case visiting:
+				for i, name := range path {
+					if name == dep {
+						cycle := append([]string{}, path[i:]...)
+						return append(cycle, dep)
+					}
+				}
+			case 0:
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[step.Name] = visited
+		return nil
+	}
+	for _, step := range all {
+		if state[step.Name] == 0 {
+			if cycle := visit(step); cycle != nil {
+				return cycle
+			}
+		}
+	}
 	return nil
 }
+
+// helper function returns true if the step defines at least
+// one non-empty command.
+func hasCommands(step *resource.Step) bool {
+	for _, command := range step.Commands {
+		if strings.TrimSpace(command) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func checkImage(os string, step *resource.Step) error {
+	if step.Image == "" {
+		if step.Pull != "" {
+			return errors.New("Linter: pull requires an image")
+		}
+		if len(step.Settings) != 0 {
+			return errors.New("Linter: settings require an image")
+		}
+		return nil
+	}
+	if os != "" && os != "linux" {
+		return errors.New("Linter: image steps require the linux platform")
+	}
+	switch step.Pull {
+	case "", "always", "never", "if-not-exists":
+		return nil
+	default:
+		return fmt.Errorf("Linter: invalid pull policy %s", step.Pull)
+	}
+}
+
+func checkShell(os, shell string) error {
+	if shell == "" {
+		return nil
+	}
+	var shells []string
+	switch os {
+	case "windows":
+		shells = []string{"powershell", "pwsh", "cmd", "python"}
+	default:
+		shells = []string{"sh", "bash", "pwsh", "python"}
+	}
+	for _, s := range shells {
+		if s == shell {
+			return nil
+		}
+	}
+	if os == "" {
+		os = "linux"
+	}
+	return fmt.Errorf("Linter: invalid shell %s for the %s platform", shell, os)
+}
+
+// Errors is returned when the pipeline breaks more than one
+// of the linting rules.
+type Errors []error
+
+// Error returns the error messages, one per line.
+func (e Errors) Error() string {
+	var lines []string
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// helper function returns nil if there are no errors, the
+// error if there is a single error, or the list of errors.
+func (e Errors) err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	default:
+		return e
+	}
+}
+
+// helper function appends the line number of the step to the
+// error message, if known.
+func lineError(step *resource.Step, err error) error {
+	if step == nil || step.Line == 0 {
+		return err
+	}
+	return fmt.Errorf("%s (line %d)", err, step.Line)
+}
+
+// helper function returns true if the path escapes the root
+// directory. Relative paths are resolved relative to the base
+// path, and absolute paths relative to the root directory.
+func escapesRoot(base, p string) bool {
+	p = strings.Replace(p, "\\", "/", -1)
+	if len(p) > 1 && p[1] == ':' {
+		// windows volume names are never relative
+		// to the root directory.
+		return true
+	}
+	if strings.HasPrefix(p, "/") {
+		base = ""
+	}
+	p = path.Clean(path.Join(strings.TrimPrefix(base, "/"), strings.TrimPrefix(p, "/")))
+	return p == ".." || strings.HasPrefix(p, "../")
+}
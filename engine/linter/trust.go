@@ -0,0 +1,112 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package linter
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-aws/engine/resource"
+)
+
+// Allowlist defines the sensitive instance settings that
+// untrusted repositories are allowed to use. Values can be
+// glob patterns.
+type Allowlist struct {
+	// IAMProfiles lists the instance profile arns.
+	IAMProfiles []string
+
+	// SecurityGroups lists the security group ids.
+	SecurityGroups []string
+
+	// Subnets lists the subnet ids.
+	Subnets []string
+
+	// InstanceTypes lists the instance types, in addition
+	// to the instance types of size large or smaller.
+	InstanceTypes []string
+
+	// CacheBuckets lists the cache bucket names.
+	CacheBuckets []string
+
+	// CacheRegions lists the cache bucket regions.
+	CacheRegions []string
+}
+
+// small instance sizes that untrusted repositories can use
+// without an allowlist.
+var smallSizes = map[string]bool{
+	"nano":   true,
+	"micro":  true,
+	"small":  true,
+	"medium": true,
+	"large":  true,
+}
+
+// checkTrust verifies the sensitive instance settings defined
+// by the pipeline are used by a trusted repository, or are
+// allowed by the runner.
+func (l *Linter) checkTrust(pipeline *resource.Pipeline, trusted bool) []error {
+	if trusted {
+		return nil
+	}
+	var errs []error
+	account := pipeline.Account
+	if account.AccessKeyID.Value != "" || account.AccessKeySecret.Value != "" {
+		errs = append(errs, errors.New("Linter: inline account credentials require a trusted repository, use from_secret instead"))
+	}
+	instance := pipeline.Instance
+	if s := instance.IAMProfileARN; s != "" && !matchAny(l.Allow.IAMProfiles, s) {
+		errs = append(errs, fmt.Errorf("Linter: iam profile %s requires a trusted repository or a runner allowlist", s))
+	}
+	var groups []string
+	groups = append(groups, instance.Network.VPCSecurityGroups...)
+	groups = append(groups, instance.Network.SecurityGroups...)
+	for _, s := range groups {
+		if !matchAny(l.Allow.SecurityGroups, s) {
+			errs = append(errs, fmt.Errorf("Linter: security group %s requires a trusted repository or a runner allowlist", s))
+		}
+	}
+	if s := instance.Network.SubnetID; s != "" && !matchAny(l.Allow.Subnets, s) {
+		errs = append(errs, fmt.Errorf("Linter: subnet %s requires a trusted repository or a runner allowlist", s))
+	}
+	if l.RequirePrivateIP && !instance.Network.PrivateIP {
+		errs = append(errs, errors.New("Linter: public ip addresses require a trusted repository, set private_ip to true"))
+	}
+	if instance.Userdata != "" {
+		errs = append(errs, errors.New("Linter: custom userdata requires a trusted repository"))
+	}
+	if s := instance.Type; s != "" && !isSmall(s) && !matchAny(l.Allow.InstanceTypes, s) {
+		errs = append(errs, fmt.Errorf("Linter: instance type %s requires a trusted repository or a runner allowlist", s))
+	}
+	cache := pipeline.Cache
+	if s := cache.Bucket; s != "" && !matchAny(l.Allow.CacheBuckets, s) {
+		errs = append(errs, fmt.Errorf("Linter: cache bucket %s requires a trusted repository or a runner allowlist", s))
+	}
+	if s := cache.Region; s != "" && !matchAny(l.Allow.CacheRegions, s) {
+		errs = append(errs, fmt.Errorf("Linter: cache region %s requires a trusted repository or a runner allowlist", s))
+	}
+	return errs
+}
+
+// helper function returns true if the instance type is of
+// size large or smaller.
+func isSmall(instanceType string) bool {
+	parts := strings.SplitN(instanceType, ".", 2)
+	return len(parts) == 2 && smallSizes[parts[1]]
+}
+
+// helper function returns true if the value matches one of
+// the glob patterns.
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
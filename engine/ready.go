@@ -0,0 +1,126 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/pipeline/runtime"
+)
+
+// interval between service readiness checks.
+var readyInterval = time.Second
+
+// helper function waits for the pipeline services to pass the
+// readiness check. Services that already passed the readiness
+// check are not checked again.
+func waitServices(ctx context.Context, spec *Spec, client transport) error {
+	for _, step := range spec.Steps {
+		if step.Ready == nil || step.RunPolicy == runtime.RunNever {
+			continue
+		}
+		if isReady(spec, step.Name) {
+			continue
+		}
+		if err := waitReady(ctx, spec, client, step); err != nil {
+			return err
+		}
+		setReady(spec, step.Name)
+	}
+	return nil
+}
+
+// helper function waits for the service to pass the readiness
+// check, or returns an error if the readiness timeout is
+// exceeded.
+func waitReady(ctx context.Context, spec *Spec, client transport, step *Step) error {
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, step.Ready.Timeout)
+	defer cancel()
+
+	log := logger.FromContext(ctx).WithField("service", step.Name)
+	for {
+		err := checkReady(ctx, client, step.Ready)
+		if err == nil {
+			log.Debug("service is ready")
+			return nil
+		}
+		log.WithError(err).Trace("service is not ready")
+
+		// the service will never pass the readiness check
+		// if the service exited.
+		if code, ok := exitCode(spec, step.Name); ok {
+			return fmt.Errorf("service %s exited with code %d", step.Name, code)
+		}
+
+		select {
+		case <-ctx.Done():
+			if parent.Err() != nil {
+				return parent.Err()
+			}
+			return fmt.Errorf("service %s is not ready after %s", step.Name, step.Ready.Timeout)
+		case <-time.After(readyInterval):
+		}
+	}
+}
+
+// helper function returns an error if the service does not
+// pass the readiness check.
+func checkReady(ctx context.Context, client transport, ready *Ready) error {
+	if ready.Port != 0 {
+		if err := client.Ping(ctx, ready.Port); err != nil {
+			return err
+		}
+	}
+	if ready.Command != "" {
+		code, err := client.Exec(ctx, ready.Command, ioutil.Discard)
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return fmt.Errorf("readiness command exited with code %d", code)
+		}
+	}
+	return nil
+}
+
+// helper function returns true if the service passed the
+// readiness check.
+func isReady(spec *Spec, name string) bool {
+	spec.mu.Lock()
+	defer spec.mu.Unlock()
+	return spec.ready[name]
+}
+
+// helper function records the service passed the readiness
+// check.
+func setReady(spec *Spec, name string) {
+	spec.mu.Lock()
+	defer spec.mu.Unlock()
+	if spec.ready == nil {
+		spec.ready = map[string]bool{}
+	}
+	spec.ready[name] = true
+}
+
+// helper function waits for the detached steps to exit, or
+// returns false if the timeout is exceeded.
+func waitDetached(spec *Spec, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		spec.detached.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
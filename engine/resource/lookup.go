@@ -6,17 +6,58 @@ package resource
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/drone/runner-go/manifest"
+
+	"github.com/buildkite/yaml"
 )
 
+// Option configures the pipeline lookup.
+type Option func(*options)
+
+type options struct {
+	strict bool
+	config string
+}
+
+// WithStrict returns an option that enables strict parsing,
+// which reports unknown fields as errors instead of silently
+// ignoring them.
+func WithStrict(strict bool) Option {
+	return func(o *options) {
+		o.strict = strict
+	}
+}
+
+// WithConfig returns an option that provides the configuration
+// file, so that line numbers are reported relative to the
+// configuration file instead of the yaml document.
+func WithConfig(config string) Option {
+	return func(o *options) {
+		o.config = config
+	}
+}
+
 // Lookup returns the named pipeline from the Manifest.
-func Lookup(name string, manifest *manifest.Manifest) (manifest.Resource, error) {
+func Lookup(name string, manifest *manifest.Manifest, opts ...Option) (manifest.Resource, error) {
+	o := new(options)
+	for _, opt := range opts {
+		opt(o)
+	}
 	for _, resource := range manifest.Resources {
 		if !isNameMatch(resource.GetName(), name) {
 			continue
 		}
 		if pipeline, ok := resource.(*Pipeline); ok {
+			offset := documentOffset(o.config, pipeline.Name)
+			offsetLines(pipeline.Steps, offset)
+			offsetLines(pipeline.Services, offset)
+			if o.strict {
+				if err := checkStrict(pipeline.Data, offset); err != nil {
+					return nil, err
+				}
+			}
 			return pipeline, nil
 		}
 	}
@@ -29,3 +70,53 @@ func isNameMatch(a, b string) bool {
 		(a == "" && b == "default") ||
 		(b == "" && a == "default")
 }
+
+// helper function returns the number of lines preceding the
+// named pipeline document in the configuration file, or zero
+// if the document is not found. The documents are split using
+// the same rules as the manifest parser.
+func documentOffset(config, name string) int {
+	if config == "" {
+		return 0
+	}
+	var doc []string
+	start := 0
+	lines := strings.Split(config, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "---") || strings.HasPrefix(line, "...") {
+			if isDocument(doc, name) {
+				return start
+			}
+			if strings.HasPrefix(line, "...") {
+				return 0
+			}
+			doc, start = nil, i+1
+			continue
+		}
+		doc = append(doc, line)
+	}
+	if isDocument(doc, name) {
+		return start
+	}
+	return 0
+}
+
+// helper function returns true if the yaml document is the
+// named pipeline document.
+func isDocument(doc []string, name string) bool {
+	raw := new(manifest.RawResource)
+	if err := yaml.Unmarshal([]byte(strings.Join(doc, "\n")), raw); err != nil {
+		return false
+	}
+	return match(raw) && raw.Name == name
+}
+
+// helper function offsets the step line numbers by the lines
+// preceding the document in the configuration file.
+func offsetLines(steps []*Step, offset int) {
+	for _, step := range steps {
+		if step != nil && step.Line != 0 {
+			step.Line += offset
+		}
+	}
+}
@@ -60,3 +60,55 @@ func TestNameMatch(t *testing.T) {
 		}
 	}
 }
+
+// This test verifies the step line numbers are offset by the
+// lines preceding the pipeline document in the configuration
+// file.
+func TestLookupConfig(t *testing.T) {
+	config := `---
+kind: pipeline
+type: docker
+name: docker
+
+---
+kind: pipeline
+type: aws
+name: default
+
+steps:
+- name: build
+  commands:
+  - go build
+`
+	m, err := manifest.ParseString(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, err := Lookup("default", m, WithConfig(config))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := res.(*Pipeline).Steps[0].Line, 12; got != want {
+		t.Errorf("Want step line %d, got %d", want, got)
+	}
+}
+
+func TestDocumentOffset(t *testing.T) {
+	config := "kind: pipeline\ntype: aws\nname: a\n---\nkind: pipeline\nname: b\n...\n---\nkind: pipeline\nname: c\n"
+	tests := []struct {
+		name   string
+		offset int
+	}{
+		{"a", 0},
+		{"b", 4},
+		{"c", 0}, // defined after the terminator
+		{"d", 0},
+	}
+	for _, test := range tests {
+		if got := documentOffset(config, test.name); got != test.offset {
+			t.Errorf("Want document %s offset %d, got %d", test.name, test.offset, got)
+		}
+	}
+}
@@ -5,7 +5,10 @@
 package resource
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
+	"strings"
 
 	"github.com/drone/runner-go/manifest"
 
@@ -26,10 +29,57 @@ func parse(r *manifest.RawResource) (manifest.Resource, bool, error) {
 	if err != nil {
 		return out, true, err
 	}
+	out.Data = r.Data
+	setLines(r.Data, "steps", out.Steps)
+	setLines(r.Data, "services", out.Services)
 	err = lint(out)
 	return out, true, err
 }
 
+// setLines sets the line number of each step defined in the
+// top-level sequence with the given key. The yaml library
+// does not expose line numbers, so the document is scanned for
+// the start of each sequence item.
+func setLines(data []byte, key string, steps []*Step) {
+	var lines []int
+	var block bool
+	indent := -1
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		depth := len(line) - len(strings.TrimLeft(line, " "))
+		isItem := trimmed == "-" || strings.HasPrefix(trimmed, "- ")
+		if depth == 0 && !isItem {
+			block = strings.HasPrefix(line, key+":")
+			indent = -1
+			continue
+		}
+		if !block || !isItem {
+			continue
+		}
+		if indent == -1 {
+			indent = depth
+		}
+		if depth == indent {
+			lines = append(lines, n)
+		}
+	}
+	// flow sequences and other uncommon syntax are not
+	// supported, in which case line numbers are omitted.
+	if len(lines) != len(steps) {
+		return
+	}
+	for i, step := range steps {
+		if step != nil {
+			step.Line = lines[i]
+		}
+	}
+}
+
 // match returns true if the resource matches the kind and type.
 func match(r *manifest.RawResource) bool {
 	return (r.Kind == Kind && r.Type == Type) ||
@@ -37,9 +87,12 @@ func match(r *manifest.RawResource) bool {
 }
 
 func lint(pipeline *Pipeline) error {
-	// ensure pipeline steps are not unique.
+	// ensure pipeline steps and services are not unique.
 	names := map[string]struct{}{}
-	for _, step := range pipeline.Steps {
+	var steps []*Step
+	steps = append(steps, pipeline.Services...)
+	steps = append(steps, pipeline.Steps...)
+	for _, step := range steps {
 		if step == nil {
 			return errors.New("Linter: detected nil step")
 		}
@@ -5,11 +5,15 @@
 package resource
 
 import (
+	"io/ioutil"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/drone/runner-go/manifest"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func TestParse(t *testing.T) {
@@ -67,6 +71,8 @@ func TestParse(t *testing.T) {
 						"GOARCH": &manifest.Variable{Value: "arm64"},
 					},
 					Failure:      "ignore",
+					Image:        "golang",
+					Line:         20,
 					When: manifest.Conditions{
 						Event: manifest.Condition{
 							Include: []string{"push"},
@@ -77,12 +83,42 @@ func TestParse(t *testing.T) {
 		},
 	}
 
-	if diff := cmp.Diff(got.Resources, want); diff != "" {
+	if diff := cmp.Diff(got.Resources, want, cmpopts.IgnoreFields(Pipeline{}, "Data")); diff != "" {
 		t.Errorf("Unexpected manifest")
 		t.Log(diff)
 	}
 }
 
+func TestSetLines(t *testing.T) {
+	data := []byte(`kind: pipeline
+services:
+  - name: redis
+    commands:
+      - redis-server
+
+steps:
+# build the binary
+- name: build
+  commands:
+  - go build
+- name: test
+  commands: [ go test ]
+`)
+	services := []*Step{{Name: "redis"}}
+	steps := []*Step{{Name: "build"}, {Name: "test"}}
+	setLines(data, "services", services)
+	setLines(data, "steps", steps)
+	if got, want := services[0].Line, 3; got != want {
+		t.Errorf("Want service line %d, got %d", want, got)
+	}
+	if got, want := steps[0].Line, 9; got != want {
+		t.Errorf("Want step line %d, got %d", want, got)
+	}
+	if got, want := steps[1].Line, 12; got != want {
+		t.Errorf("Want step line %d, got %d", want, got)
+	}
+}
+
 func TestParseErr(t *testing.T) {
 	_, err := manifest.ParseFile("testdata/malformed.yml")
 	if err == nil {
@@ -166,4 +202,124 @@ func TestLint(t *testing.T) {
 	if err := lint(p); err == nil {
 		t.Errorf("Expect error when empty name")
 	}
+
+	p.Services = []*Step{
+		{Name: "build"},
+	}
+	p.Steps = []*Step{
+		{Name: "build"},
+	}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect error when service and step have duplicate name")
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	m, err := manifest.ParseFile("testdata/unknown.yml")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = Lookup("default", m, WithStrict(true))
+	if err == nil {
+		t.Errorf("Expect unknown field errors")
+		return
+	}
+	want := []string{
+		"yaml: unknown field instace (line 5), did you mean instance?",
+		"yaml: unknown field instance.network.subnetid (line 14), did you mean subnet_id?",
+		"yaml: unknown field steps[1].comands (line 21), did you mean commands?",
+	}
+	if got := strings.Split(err.Error(), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected errors %q", got)
+	}
+}
+
+// This test verifies the line numbers are relative to the
+// configuration file when the configuration file is provided.
+func TestParseStrict_Config(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/unknown.yml")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	config := "---\nkind: pipeline\ntype: docker\nname: docker\n\n" + string(raw)
+	m, err := manifest.ParseString(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = Lookup("default", m, WithStrict(true), WithConfig(config))
+	if err == nil {
+		t.Errorf("Expect unknown field errors")
+		return
+	}
+	want := []string{
+		"yaml: unknown field instace (line 11), did you mean instance?",
+		"yaml: unknown field instance.network.subnetid (line 20), did you mean subnet_id?",
+		"yaml: unknown field steps[1].comands (line 27), did you mean commands?",
+	}
+	if got := strings.Split(err.Error(), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected errors %q", got)
+	}
+}
+
+func TestParseStrict_Disabled(t *testing.T) {
+	m, err := manifest.ParseFile("testdata/unknown.yml")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := Lookup("default", m, WithStrict(false)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFieldPath(t *testing.T) {
+	data := []byte(`kind: pipeline
+steps:
+- name: build
+  settings:
+    foo: bar
+- name: test
+  when:
+    branch:
+    - master
+    evnt: push
+services:
+  - name: redis
+    imag: redis
+`)
+	tests := []struct {
+		line int
+		path string
+	}{
+		{1, "kind"},
+		{5, "steps[0].settings.foo"},
+		{6, "steps[1].name"},
+		{10, "steps[1].when.evnt"},
+		{13, "services[0].imag"},
+		{99, ""},
+	}
+	for _, test := range tests {
+		if got, want := fieldPath(data, test.line), test.path; got != want {
+			t.Errorf("Want path %q at line %d, got %q", want, test.line, got)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	fields := []string{"commands", "depends_on", "image", "working_dir"}
+	tests := map[string]string{
+		"comands":    "commands",
+		"dependson":  "depends_on",
+		"workingDir": "working_dir",
+		"imgae":      "image",
+		"foo":        "",
+	}
+	for name, want := range tests {
+		if got := suggest(name, fields); got != want {
+			t.Errorf("Want suggestion %q for %s, got %q", want, name, got)
+		}
+	}
 }
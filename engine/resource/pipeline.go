@@ -4,7 +4,11 @@
 
 package resource
 
-import "github.com/drone/runner-go/manifest"
+import (
+	"time"
+
+	"github.com/drone/runner-go/manifest"
+)
 
 var (
 	_ manifest.Resource          = (*Pipeline)(nil)
@@ -26,7 +30,7 @@ type Pipeline struct {
 	Kind    string   `json:"kind,omitempty"`
 	Type    string   `json:"type,omitempty"`
 	Name    string   `json:"name,omitempty"`
-	Deps    []string `json:"depends_on,omitempty"`
+	Deps    []string `json:"depends_on,omitempty" yaml:"depends_on"`
 
 	Clone       manifest.Clone       `json:"clone,omitempty"`
 	Concurrency manifest.Concurrency `json:"concurrency,omitempty"`
@@ -35,10 +39,20 @@ type Pipeline struct {
 	Trigger     manifest.Conditions  `json:"conditions,omitempty"`
 
 	Account     Account           `json:"account,omitempty"`
+	Artifacts   []string          `json:"artifacts,omitempty"`
+	Cache       Cache             `json:"cache,omitempty"`
 	Instance    Instance          `json:"instance,omitempty"`
+	Pool        string            `json:"pool,omitempty"`
 	Environment map[string]string `json:"environment,omitempty"`
+	PullSecrets []string          `json:"image_pull_secrets,omitempty" yaml:"image_pull_secrets"`
+	Services    []*Step           `json:"services,omitempty"`
 	Steps       []*Step           `json:"steps,omitempty"`
+	Timeout     time.Duration     `json:"timeout,omitempty"`
 	Workspace   Workspace         `json:"workspace,omitempty"`
+
+	// Data is the raw yaml document, used to verify the
+	// document in strict mode.
+	Data []byte `json:"-" yaml:"-"`
 }
 
 // GetVersion returns the resource version.
@@ -82,15 +96,45 @@ func (p *Pipeline) GetStep(name string) *Step {
 type (
 	// Step defines a Pipeline step.
 	Step struct {
-		Commands    []string                      `json:"commands,omitempty"`
-		Detach      bool                          `json:"detach,omitempty"`
-		DependsOn   []string                      `json:"depends_on,omitempty" yaml:"depends_on"`
-		Environment map[string]*manifest.Variable `json:"environment,omitempty"`
-		Failure     string                        `json:"failure,omitempty"`
-		Name        string                        `json:"name,omitempty"`
-		Shell       string                        `json:"shell,omitempty"`
-		When        manifest.Conditions           `json:"when,omitempty"`
-		WorkingDir  string                        `json:"working_dir,omitempty" yaml:"working_dir"`
+		Commands    []string                       `json:"commands,omitempty"`
+		Detach      bool                           `json:"detach,omitempty"`
+		DependsOn   []string                       `json:"depends_on,omitempty" yaml:"depends_on"`
+		Environment map[string]*manifest.Variable  `json:"environment,omitempty"`
+		Failure     string                         `json:"failure,omitempty"`
+		Image       string                         `json:"image,omitempty"`
+		Name        string                         `json:"name,omitempty"`
+		Pull        string                         `json:"pull,omitempty"`
+		Ready       Ready                          `json:"ready,omitempty"`
+		Settings    map[string]*manifest.Parameter `json:"settings,omitempty"`
+		Shell       string                         `json:"shell,omitempty"`
+		Timeout     time.Duration                  `json:"timeout,omitempty"`
+		When        manifest.Conditions            `json:"when,omitempty"`
+		WorkingDir  string                         `json:"working_dir,omitempty" yaml:"working_dir"`
+
+		// Line is the line number of the step, used to
+		// reference the step in errors. The line number is
+		// relative to the start of the yaml document, or to
+		// the configuration file if provided to Lookup.
+		Line int `json:"-" yaml:"-"`
+	}
+
+	// Ready defines the readiness check of a service. The
+	// service is ready when the port accepts connections, or
+	// when the command exits successfully.
+	Ready struct {
+		Port    int           `json:"port,omitempty"`
+		Command string        `json:"command,omitempty"`
+		Timeout time.Duration `json:"timeout,omitempty"`
+	}
+
+	// Cache defines the pipeline cache, which is restored
+	// before the first step and saved after the last step.
+	Cache struct {
+		Bucket      string   `json:"bucket,omitempty"`
+		Region      string   `json:"region,omitempty"`
+		Key         string   `json:"key,omitempty"`
+		RestoreKeys []string `json:"restore_keys,omitempty" yaml:"restore_keys"`
+		Paths       []string `json:"paths,omitempty"`
 	}
 
 	// Workspace represents the pipeline workspace configuration.
@@ -103,6 +147,10 @@ type (
 		AccessKeyID     manifest.Variable `json:"access_key_id,omitempty"     yaml:"access_key_id"`
 		AccessKeySecret manifest.Variable `json:"secret_access_key,omitempty" yaml:"secret_access_key"`
 		Region          string            `json:"region,omitempty"`
+
+		// Name references a named account configured by the
+		// runner, which provides the account credentials.
+		Name string `json:"name,omitempty"`
 	}
 
 	// Instance provides instance settings.
@@ -114,6 +162,22 @@ type (
 		Network Network `json:"network,omitempty"`
 		Market  string  `json:"market_type,omitempty" yaml:"market_type"`
 		Device  Device  `json:"device,omitempty"`
+
+		// Transport defines how the runner connects to the
+		// instance, either ssh (default) or winrm.
+		Transport string `json:"transport,omitempty"`
+
+		// Userdata defines custom cloud-config or shell
+		// userdata merged with the generated userdata.
+		Userdata string `json:"userdata,omitempty"`
+
+		// IAMProfileARN defines the instance profile attached
+		// to the instance.
+		IAMProfileARN string `json:"iam_profile_arn,omitempty" yaml:"iam_profile_arn"`
+
+		// Docker declares the AMI has docker installed, which
+		// is required by steps that define an image.
+		Docker bool `json:"docker,omitempty"`
 	}
 
 	// Network provides network settings.
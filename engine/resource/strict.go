@@ -0,0 +1,240 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/buildkite/yaml"
+)
+
+// regular expression matches the unknown field errors
+// reported by the yaml decoder.
+var unknownField = regexp.MustCompile(`^line (\d+): field (.+) not found in type (\S+)$`)
+
+// helper function decodes the yaml document in strict mode
+// and returns an error listing the unknown fields, with the
+// path, line number and the closest known field name. The
+// line numbers are offset by the lines preceding the document
+// in the configuration file.
+func checkStrict(data []byte, offset int) error {
+	err := yaml.UnmarshalStrict(data, new(Pipeline))
+	terr, ok := err.(*yaml.TypeError)
+	if !ok {
+		return err
+	}
+	fields := knownFields()
+	var lines []string
+	for _, issue := range terr.Errors {
+		match := unknownField.FindStringSubmatch(issue)
+		if match == nil {
+			lines = append(lines, "yaml: "+issue)
+			continue
+		}
+		line, _ := strconv.Atoi(match[1])
+		name := match[2]
+		msg := fmt.Sprintf("yaml: unknown field %s (line %d)", name, line+offset)
+		if p := fieldPath(data, line); p != "" {
+			msg = fmt.Sprintf("yaml: unknown field %s (line %d)", p, line+offset)
+		}
+		if s := suggest(name, fields[match[3]]); s != "" {
+			msg = fmt.Sprintf("%s, did you mean %s?", msg, s)
+		}
+		lines = append(lines, msg)
+	}
+	return errors.New(strings.Join(lines, "\n"))
+}
+
+// helper function returns the yaml field names of the
+// pipeline types, indexed by type name.
+func knownFields() map[string][]string {
+	fields := map[string][]string{}
+	var visit func(t reflect.Type)
+	visit = func(t reflect.Type) {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map:
+			visit(t.Elem())
+			return
+		case reflect.Struct:
+		default:
+			return
+		}
+		if _, ok := fields[t.String()]; ok {
+			return
+		}
+		fields[t.String()] = nil
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			fields[t.String()] = append(fields[t.String()], name)
+			visit(field.Type)
+		}
+	}
+	visit(reflect.TypeOf(Pipeline{}))
+	return fields
+}
+
+// helper function returns the known field name closest to the
+// unknown field name, or an empty string if no field name is
+// close enough to be a likely typo.
+func suggest(name string, fields []string) string {
+	var best string
+	min := len(name)/3 + 1
+	for _, field := range fields {
+		d := distance(normalize(name), normalize(field))
+		if d < min {
+			best, min = field, d
+		}
+	}
+	return best
+}
+
+// helper function normalizes the field name, so that names
+// that differ only by case or separators are equivalent.
+func normalize(s string) string {
+	s = strings.ToLower(s)
+	s = strings.Replace(s, "_", "", -1)
+	s = strings.Replace(s, "-", "", -1)
+	return s
+}
+
+// helper function returns the edit distance between two
+// strings, counting transposed characters as a single edit.
+func distance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// helper function returns the smallest value.
+func minInt(values ...int) int {
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// helper function returns the path of the field defined at
+// the line number, for example steps[0].commands. The yaml
+// library does not expose the document structure, so the path
+// is derived from the indentation of the preceding lines.
+func fieldPath(data []byte, line int) string {
+	lines := strings.Split(string(data), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	indent, col, name, isItem := parseKey(lines[line-1])
+	if name == "" {
+		return ""
+	}
+	path := []string{name}
+
+	// dash is the column of the enclosing sequence item, or
+	// -1 if the current node is not a sequence item, and index
+	// is the position of the item in the sequence.
+	dash, index := -1, 0
+	if isItem {
+		dash = indent
+	}
+	for i := line - 2; i >= 0; i-- {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent, keyCol, key, isItem := parseKey(lines[i])
+		switch {
+		case dash != -1 && isItem && indent == dash:
+			index++
+			continue
+		case dash != -1 && indent > dash:
+			continue
+		case dash != -1:
+			path = append([]string{fmt.Sprintf("%s[%d]", key, index)}, path...)
+		case key != "" && keyCol < col:
+			path = append([]string{key}, path...)
+		case isItem && indent < col:
+			// the node is defined in a sequence item, which
+			// starts on a preceding line.
+			dash, index = indent, 0
+			continue
+		default:
+			continue
+		}
+		if key == "" {
+			return ""
+		}
+		dash, index, col = -1, 0, keyCol
+		if isItem {
+			dash = indent
+		}
+	}
+	if dash != -1 {
+		// sequence items are expected to belong to a mapping
+		// key, since the document root is a mapping.
+		return ""
+	}
+	return strings.Join(path, ".")
+}
+
+// helper function returns the indentation of the line, the
+// column and name of the mapping key defined on the line, and
+// true if the line starts a sequence item.
+func parseKey(s string) (int, int, string, bool) {
+	trimmed := strings.TrimLeft(s, " ")
+	indent := len(s) - len(trimmed)
+	col := indent
+	isItem := isSequenceItem(trimmed)
+	if isItem {
+		rest := strings.TrimLeft(trimmed[1:], " ")
+		col += len(trimmed) - len(rest)
+		trimmed = rest
+	}
+	i := strings.Index(trimmed, ":")
+	if i == -1 {
+		return indent, col, "", isItem
+	}
+	return indent, col, strings.Trim(trimmed[:i], `"'`), isItem
+}
+
+// helper function returns true if the trimmed line is the
+// start of a sequence item.
+func isSequenceItem(trimmed string) bool {
+	return trimmed == "-" || strings.HasPrefix(trimmed, "- ")
+}
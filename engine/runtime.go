@@ -0,0 +1,61 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "github.com/drone-runners/drone-runner-aws/internal/platform"
+
+// RuntimeEnviron lists the names of the environment variables
+// injected into each step when the instance is provisioned.
+var RuntimeEnviron = []string{
+	"DRONE_AWS_INSTANCE_ID",
+	"DRONE_AWS_INSTANCE_TYPE",
+	"DRONE_AWS_REGION",
+	"DRONE_AWS_AZ",
+	"DRONE_AWS_AMI",
+	"DRONE_AWS_PRIVATE_IP",
+	"DRONE_AWS_MARKET_TYPE",
+}
+
+// helper function returns the runtime details of the
+// provisioned instance.
+func convertRuntime(instance *platform.Instance) *Runtime {
+	return &Runtime{
+		InstanceID:       instance.ID,
+		InstanceType:     instance.Type,
+		Region:           instance.Region,
+		AvailabilityZone: instance.Zone,
+		AMI:              instance.Image,
+		PrivateIP:        instance.PrivateIP,
+		Market:           instance.Market,
+	}
+}
+
+// helper function returns the environment variables that
+// describe the provisioned instance.
+func runtimeEnviron(r *Runtime) map[string]string {
+	return map[string]string{
+		"DRONE_AWS_INSTANCE_ID":   r.InstanceID,
+		"DRONE_AWS_INSTANCE_TYPE": r.InstanceType,
+		"DRONE_AWS_REGION":        r.Region,
+		"DRONE_AWS_AZ":            r.AvailabilityZone,
+		"DRONE_AWS_AMI":           r.AMI,
+		"DRONE_AWS_PRIVATE_IP":    r.PrivateIP,
+		"DRONE_AWS_MARKET_TYPE":   r.Market,
+	}
+}
+
+// helper function injects the environment variables that
+// describe the provisioned instance into each step.
+func injectRuntime(spec *Spec) {
+	envs := runtimeEnviron(spec.Runtime)
+	for _, step := range spec.Steps {
+		if step.Envs == nil {
+			step.Envs = map[string]string{}
+		}
+		for k, v := range envs {
+			step.Envs[k] = v
+		}
+	}
+}
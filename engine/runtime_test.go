@@ -0,0 +1,53 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-aws/internal/platform"
+)
+
+func Test_injectRuntime(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{
+			{Name: "build", Envs: map[string]string{"GOOS": "linux"}},
+			{Name: "test"},
+		},
+	}
+	spec.Runtime = convertRuntime(&platform.Instance{
+		ID:        "i-1234",
+		Type:      "t3.nano",
+		Region:    "us-east-1",
+		Zone:      "us-east-1a",
+		Image:     "ami-12345",
+		PrivateIP: "10.0.0.1",
+		Market:    "spot",
+	})
+	injectRuntime(spec)
+
+	want := map[string]string{
+		"DRONE_AWS_INSTANCE_ID":   "i-1234",
+		"DRONE_AWS_INSTANCE_TYPE": "t3.nano",
+		"DRONE_AWS_REGION":        "us-east-1",
+		"DRONE_AWS_AZ":            "us-east-1a",
+		"DRONE_AWS_AMI":           "ami-12345",
+		"DRONE_AWS_PRIVATE_IP":    "10.0.0.1",
+		"DRONE_AWS_MARKET_TYPE":   "spot",
+	}
+	for _, step := range spec.Steps {
+		for k, v := range want {
+			if got := step.Envs[k]; got != v {
+				t.Errorf("Want step %s env %s=%q, got %q", step.Name, k, v, got)
+			}
+		}
+	}
+	if got := spec.Steps[0].Envs["GOOS"]; got != "linux" {
+		t.Errorf("Want step environment preserved")
+	}
+	if got, want := len(RuntimeEnviron), len(want); got != want {
+		t.Errorf("Want %d runtime environment names, got %d", want, got)
+	}
+}
@@ -5,6 +5,11 @@
 package engine
 
 import (
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-aws/internal/platform"
+
 	"github.com/drone/runner-go/environ"
 	"github.com/drone/runner-go/pipeline/runtime"
 )
@@ -15,19 +20,55 @@ type (
 	// required instructions for reproducible pipeline
 	// execution.
 	Spec struct {
-		Root     string   `json:"root,omitempty"`
-		Platform Platform `json:"platform,omitempty"`
-		Account  Account  `json:"account,omitempty"`
-		Instance Instance `json:"instance,omitempty"`
-		Files    []*File  `json:"files,omitempty"`
-		Steps    []*Step  `json:"steps,omitempty"`
+		Root     string        `json:"root,omitempty"`
+		Platform Platform      `json:"platform,omitempty"`
+		Account  Account       `json:"account,omitempty"`
+		Instance Instance      `json:"instance,omitempty"`
+		Files    []*File       `json:"files,omitempty"`
+		Steps    []*Step       `json:"steps,omitempty"`
+		Timeout  time.Duration `json:"timeout,omitempty"`
+
+		// Error is set by the compiler when the pipeline cannot
+		// be compiled, and fails the pipeline when the pipeline
+		// environment is created.
+		Error string `json:"error,omitempty"`
+
+		// Runtime provides the details of the provisioned
+		// instance, and is populated by the engine when the
+		// pipeline environment is created.
+		Runtime *Runtime `json:"runtime,omitempty"`
+
+		// instance and credentials are populated by the
+		// engine when the pipeline environment is created.
+		instance   *platform.Instance
+		privateKey string
+		password   string
+
+		// deadline is the time at which the pipeline
+		// timeout is exceeded.
+		deadline time.Time
+
+		// detached tracks the detached steps running on the
+		// instance, ready tracks the services that passed the
+		// readiness check, and exited tracks the exit codes
+		// of the detached steps that exited.
+		detached sync.WaitGroup
+		mu       sync.Mutex
+		ready    map[string]bool
+		exited   map[string]int
 	}
 
 	// Account provides account settings
 	Account struct {
 		AccessKeyID     string `json:"access_key_id,omitempty"`
 		AccessKeySecret string `json:"secret_access_key,omitempty"`
+		RoleARN         string `json:"role_arn,omitempty"`
+		ExternalID      string `json:"external_id,omitempty"`
 		Region          string `json:"region,omitempty"`
+
+		// Default allows the default credential chain of the
+		// runner when the access keys are not provided.
+		Default bool `json:"default,omitempty"`
 	}
 
 	// Instance provides instance settings.
@@ -40,11 +81,33 @@ type (
 		Market  string  `json:"market_type,omitempty"`
 		Device  Device  `json:"device,omitempty"`
 
+		// Transport defines how the runner connects to the
+		// instance, either ssh or winrm.
+		Transport string `json:"transport,omitempty"`
+
+		// Userdata defines custom userdata merged with the
+		// generated userdata when the instance is created.
+		Userdata string `json:"userdata,omitempty"`
+
+		// IAMProfileARN defines the instance profile attached
+		// to the instance.
+		IAMProfileARN string `json:"iam_profile_arn,omitempty"`
+
 		// availability_zone
 		// placement_group
 		// tenancy
+	}
 
-		// iam_instance_profile
+	// Runtime provides the details of the provisioned
+	// instance.
+	Runtime struct {
+		InstanceID       string `json:"instance_id,omitempty"`
+		InstanceType     string `json:"instance_type,omitempty"`
+		Region           string `json:"region,omitempty"`
+		AvailabilityZone string `json:"availability_zone,omitempty"`
+		AMI              string `json:"ami,omitempty"`
+		PrivateIP        string `json:"private_ip,omitempty"`
+		Market           string `json:"market_type,omitempty"`
 	}
 
 	// Network provides network settings.
@@ -81,12 +144,43 @@ type (
 		ErrPolicy  runtime.ErrPolicy `json:"err_policy,omitempty"`
 		Envs       map[string]string `json:"environment,omitempty"`
 		Files      []*File           `json:"files,omitempty"`
+		Image      string            `json:"image,omitempty"`
+		Container  string            `json:"container,omitempty"`
+		Pull       string            `json:"pull,omitempty"`
+		Config     string            `json:"docker_config,omitempty"`
 		Name       string            `json:"name,omitempt"`
+		Cache      *Cache            `json:"cache,omitempty"`
+		Artifacts  []string          `json:"artifacts,omitempty"`
+		Ready      *Ready            `json:"ready,omitempty"`
 		RunPolicy  runtime.RunPolicy `json:"run_policy,omitempty"`
 		Secrets    []*Secret         `json:"secrets,omitempty"`
+		Shell      string            `json:"shell,omitempty"`
+		Timeout    time.Duration     `json:"timeout,omitempty"`
 		WorkingDir string            `json:"working_dir,omitempty"`
 	}
 
+	// Cache defines the cache restored or saved by a
+	// cache step.
+	Cache struct {
+		Rebuild     bool     `json:"rebuild,omitempty"`
+		Bucket      string   `json:"bucket,omitempty"`
+		Region      string   `json:"region,omitempty"`
+		Key         string   `json:"key,omitempty"`
+		RestoreKeys []string `json:"restore_keys,omitempty"`
+		Paths       []string `json:"paths,omitempty"`
+
+		// Namespace prefixes the cache keys, and is the
+		// repository slug.
+		Namespace string `json:"namespace,omitempty"`
+	}
+
+	// Ready defines the readiness check of a service.
+	Ready struct {
+		Port    int           `json:"port,omitempty"`
+		Command string        `json:"command,omitempty"`
+		Timeout time.Duration `json:"timeout,omitempty"`
+	}
+
 	// Secret represents a secret variable.
 	Secret struct {
 		Name string `json:"name,omitempty"`
@@ -0,0 +1,283 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/drone-runners/drone-runner-aws/internal/ssh"
+	"github.com/drone-runners/drone-runner-aws/internal/winrm"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/pkg/sftp"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// transport provides remote access to the instance. It is
+// used to create the pipeline files and execute the pipeline
+// steps.
+type transport interface {
+	// Mkdir creates the named directory.
+	Mkdir(ctx context.Context, path string, mode uint32) error
+
+	// Upload writes the data to the named file.
+	Upload(ctx context.Context, path string, data []byte, mode uint32) error
+
+	// Exec executes the command, writes the output to the
+	// writer and returns the exit code.
+	Exec(ctx context.Context, cmd string, output io.Writer) (int, error)
+
+	// ReadFile reads the named file, starting at the offset.
+	ReadFile(ctx context.Context, path string, offset int64) ([]byte, error)
+
+	// UploadFrom writes the contents of the reader to the
+	// named file, without buffering the contents in memory.
+	UploadFrom(ctx context.Context, path string, r io.Reader, mode uint32) error
+
+	// DownloadTo copies the named file to the writer, without
+	// buffering the file in memory.
+	DownloadTo(ctx context.Context, path string, w io.Writer) error
+
+	// Ping returns an error if the local port on the
+	// instance does not accept connections.
+	Ping(ctx context.Context, port int) error
+
+	// Close closes the transport.
+	Close() error
+}
+
+// helper function dials the instance using the transport
+// configured for the pipeline. If retry is true, the dial is
+// retried until the instance is reachable or times out.
+func dial(ctx context.Context, spec *Spec, retry bool) (transport, error) {
+	switch spec.Instance.Transport {
+	case "winrm":
+		return dialWinRM(ctx, spec, retry)
+	default:
+		return dialSSH(ctx, spec, retry)
+	}
+}
+
+//
+// ssh transport
+//
+
+type sshTransport struct {
+	client *cryptossh.Client
+	ftp    *sftp.Client
+	os     string
+}
+
+func dialSSH(ctx context.Context, spec *Spec, retry bool) (transport, error) {
+	var client *cryptossh.Client
+	var err error
+	if retry {
+		client, err = ssh.DialRetry(ctx, spec.instance.IP, spec.Instance.User, spec.privateKey)
+	} else {
+		client, err = ssh.Dial(spec.instance.IP, spec.Instance.User, spec.privateKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	clientftp, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &sshTransport{
+		client: client,
+		ftp:    clientftp,
+		os:     spec.Platform.OS,
+	}, nil
+}
+
+func (t *sshTransport) Mkdir(ctx context.Context, path string, mode uint32) error {
+	return mkdir(t.ftp, remotePath(t.os, path), mode)
+}
+
+func (t *sshTransport) Upload(ctx context.Context, path string, data []byte, mode uint32) error {
+	return upload(t.ftp, remotePath(t.os, path), data, mode)
+}
+
+func (t *sshTransport) Exec(ctx context.Context, cmd string, output io.Writer) (int, error) {
+	session, err := t.client.NewSession()
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+
+	// the session copies stdout and stderr concurrently,
+	// so writes to the output are serialized.
+	w := &syncWriter{w: output}
+	session.Stdout = w
+	session.Stderr = w
+
+	log := logger.FromContext(ctx)
+	log.Debug("ssh session started")
+
+	// the process id of the remote process is written to a
+	// file so that the process can be killed from a separate
+	// session if the context is cancelled.
+	pidfile := tempPath(t.os) + ".pid"
+
+	done := make(chan error)
+	go func() {
+		done <- session.Run(pidScript(t.os, pidfile, cmd))
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// BUG: openssh does not support the signal command
+		// prior to version 7.9 and may not signal the remote
+		// process. See https://github.com/golang/go/issues/16597
+		if err := session.Signal(cryptossh.SIGKILL); err != nil {
+			log.WithError(err).Debug("signal remote process")
+		}
+		// we therefore also kill the remote process, and its
+		// children, using the process id.
+		if err := t.kill(pidfile); err != nil {
+			log.WithError(err).Debug("kill remote process")
+		}
+		log.Debug("ssh session killed")
+		return 0, ctx.Err()
+	}
+
+	if exiterr, ok := err.(*cryptossh.ExitError); ok {
+		return exiterr.ExitStatus(), nil
+	}
+	return 0, err
+}
+
+func (t *sshTransport) ReadFile(ctx context.Context, path string, offset int64) ([]byte, error) {
+	f, err := t.ftp.Open(remotePath(t.os, path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(f)
+}
+
+func (t *sshTransport) UploadFrom(ctx context.Context, path string, r io.Reader, mode uint32) error {
+	f, err := t.ftp.Create(remotePath(t.os, path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	return f.Chmod(os.FileMode(mode))
+}
+
+func (t *sshTransport) DownloadTo(ctx context.Context, path string, w io.Writer) error {
+	f, err := t.ftp.Open(remotePath(t.os, path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (t *sshTransport) Ping(ctx context.Context, port int) error {
+	conn, err := t.client.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// helper function kills the remote process, and its children,
+// using the process id written to the pidfile.
+func (t *sshTransport) kill(pidfile string) error {
+	session, err := t.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return session.Run(killScript(t.os, pidfile))
+}
+
+func (t *sshTransport) Close() error {
+	t.ftp.Close()
+	return t.client.Close()
+}
+
+// syncWriter serializes writes to the underlying writer.
+type syncWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	return w.w.Write(p)
+}
+
+//
+// winrm transport
+//
+
+type winrmTransport struct {
+	client *winrm.Client
+}
+
+func dialWinRM(ctx context.Context, spec *Spec, retry bool) (transport, error) {
+	var client *winrm.Client
+	var err error
+	if retry {
+		client, err = winrm.DialRetry(ctx, spec.instance.IP, spec.Instance.User, spec.password)
+	} else {
+		client, err = winrm.Dial(spec.instance.IP, spec.Instance.User, spec.password)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &winrmTransport{client: client}, nil
+}
+
+func (t *winrmTransport) Mkdir(ctx context.Context, path string, mode uint32) error {
+	return t.client.Mkdir(ctx, path)
+}
+
+func (t *winrmTransport) Upload(ctx context.Context, path string, data []byte, mode uint32) error {
+	return t.client.Upload(ctx, path, data)
+}
+
+func (t *winrmTransport) Exec(ctx context.Context, cmd string, output io.Writer) (int, error) {
+	return t.client.Run(ctx, cmd, output, output)
+}
+
+func (t *winrmTransport) ReadFile(ctx context.Context, path string, offset int64) ([]byte, error) {
+	return t.client.ReadFile(ctx, path, offset)
+}
+
+func (t *winrmTransport) UploadFrom(ctx context.Context, path string, r io.Reader, mode uint32) error {
+	return t.client.UploadFrom(ctx, path, r)
+}
+
+func (t *winrmTransport) DownloadTo(ctx context.Context, path string, w io.Writer) error {
+	return t.client.DownloadTo(ctx, path, w)
+}
+
+func (t *winrmTransport) Ping(ctx context.Context, port int) error {
+	return t.client.Ping(ctx, port)
+}
+
+func (t *winrmTransport) Close() error {
+	return nil
+}
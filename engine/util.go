@@ -0,0 +1,402 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/dchest/uniuri"
+	"github.com/pkg/sftp"
+)
+
+// helper function writes the shell preamble to the io.Writer,
+// which must precede all other shell commands.
+func writeHeader(w io.Writer, shell string) {
+	switch shell {
+	case "cmd":
+		fmt.Fprintln(w, "@echo off")
+	case "python":
+		fmt.Fprintln(w, "import os")
+	}
+}
+
+// helper function writes a shell command to the io.Writer that
+// changes the current working directory.
+func writeWorkdir(w io.Writer, shell, path string) {
+	if path == "" {
+		return
+	}
+	switch shell {
+	case "cmd":
+		fmt.Fprintf(w, "cd /d %s", quote(shell, path))
+	case "python":
+		fmt.Fprintf(w, "os.chdir(%s)", quote(shell, path))
+	default:
+		fmt.Fprintf(w, "cd %s", quote(shell, path))
+	}
+	fmt.Fprintln(w)
+}
+
+// helper function writes a shell command to the io.Writer that
+// exports all secrets as environment variables.
+func writeSecrets(w io.Writer, shell string, secrets []*Secret) {
+	for _, s := range secrets {
+		writeEnv(w, shell, s.Env, string(s.Data))
+	}
+}
+
+// helper function writes a shell command to the io.Writer that
+// exports the key value pairs as environment variables.
+func writeEnviron(w io.Writer, shell string, envs map[string]string) {
+	var keys []string
+	for k := range envs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeEnv(w, shell, k, envs[k])
+	}
+}
+
+// helper function writes a shell command to the io.Writer that
+// exports the key value pair as an environment variable.
+func writeEnv(w io.Writer, shell, key, value string) {
+	switch shell {
+	case "powershell", "pwsh":
+		fmt.Fprintf(w, "$Env:%s = %s", key, quote(shell, value))
+	case "cmd":
+		fmt.Fprintf(w, "set %s=%s", key, escapeCmd(value))
+	case "python":
+		fmt.Fprintf(w, "os.environ[%q] = %s", key, quote(shell, value))
+	default:
+		fmt.Fprintf(w, "export %s=%s", key, quote(shell, value))
+	}
+	fmt.Fprintln(w)
+}
+
+// helper function returns the value quoted for the shell, so
+// that the value is never expanded or interpreted.
+func quote(shell, value string) string {
+	switch shell {
+	case "powershell", "pwsh":
+		return "'" + strings.Replace(value, "'", "''", -1) + "'"
+	case "cmd":
+		return "\"" + strings.Replace(value, "%", "%%", -1) + "\""
+	case "python":
+		return fmt.Sprintf("%q", value)
+	default:
+		return "'" + strings.Replace(value, "'", `'"'"'`, -1) + "'"
+	}
+}
+
+// cmdEscaper escapes the characters interpreted by the cmd
+// shell in a batch file. The caret escapes the character, and
+// the percent sign is escaped by doubling.
+var cmdEscaper = strings.NewReplacer(
+	"%", "%%",
+	"^", "^^",
+	"&", "^&",
+	"|", "^|",
+	"<", "^<",
+	">", "^>",
+	"(", "^(",
+	")", "^)",
+	"\"", "^\"",
+)
+
+// helper function returns the value escaped for the cmd shell,
+// so that the value is never expanded or interpreted. The value
+// is not quoted, since a quote in the value cannot be escaped
+// inside a quoted string.
+func escapeCmd(value string) string {
+	return cmdEscaper.Replace(value)
+}
+
+// helper function returns an error if an environment variable
+// cannot be set in the shell. The cmd shell cannot set values
+// that contain line breaks.
+func checkEnv(shell string, step *Step) error {
+	if shell != "cmd" {
+		return nil
+	}
+	for _, s := range step.Secrets {
+		if strings.ContainsAny(string(s.Data), "\r\n") {
+			return fmt.Errorf("cannot set secret %s in the cmd shell: the value contains a line break", s.Env)
+		}
+	}
+	for k, v := range step.Envs {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("cannot set %s in the cmd shell: the value contains a line break", k)
+		}
+	}
+	return nil
+}
+
+// helper function returns a timeout-specific error if the step
+// was cancelled because the step or pipeline timeout was
+// exceeded, as opposed to the parent context being cancelled.
+func checkTimeout(parent context.Context, spec *Spec, step *Step, err error) error {
+	if err != context.DeadlineExceeded || parent.Err() != nil {
+		return err
+	}
+	if !spec.deadline.IsZero() && !time.Now().Before(spec.deadline) {
+		return fmt.Errorf("pipeline exceeded the %s timeout", spec.Timeout)
+	}
+	if step.Timeout > 0 {
+		return fmt.Errorf("step exceeded the %s timeout", step.Timeout)
+	}
+	return err
+}
+
+// helper function returns a unique path, without extension,
+// on the remote server used to store process state such as the
+// process id, output and exit code.
+func tempPath(os string) string {
+	switch os {
+	case "windows":
+		return fmt.Sprintf("C:/Windows/Temp/drone-%s", uniuri.NewLen(8))
+	default:
+		return fmt.Sprintf("/tmp/drone-%s", uniuri.NewLen(8))
+	}
+}
+
+// helper function returns the remote command that writes the
+// process id of the remote shell to the pidfile before it
+// executes the command. On windows the remote shell is
+// powershell, configured by the userdata.
+func pidScript(os, pidfile, cmd string) string {
+	switch os {
+	case "windows":
+		return fmt.Sprintf("$PID | Out-File -Encoding ascii %s; %s; exit $LASTEXITCODE", pidfile, cmd)
+	default:
+		return fmt.Sprintf("echo $$ > %s; %s", pidfile, cmd)
+	}
+}
+
+// helper function returns the remote command that kills the
+// process written to the pidfile, and all of its children.
+// The openssh server starts each session in a new process
+// group, which is killed on posix systems.
+func killScript(os, pidfile string) string {
+	switch os {
+	case "windows":
+		return fmt.Sprintf("taskkill /F /T /PID (Get-Content %s)", pidfile)
+	default:
+		return fmt.Sprintf("kill -9 -$(cat %s)", pidfile)
+	}
+}
+
+// helper function returns the remote command that starts the
+// command in the background, detached from the session. The
+// process id, output and exit code of the command are written
+// to files with the base path. On windows the process is created
+// with wmi, since processes started in a winrm or ssh session
+// are terminated with the session.
+func detachScript(os, base, cmd string) string {
+	switch os {
+	case "windows":
+		script := fmt.Sprintf(detachPowershell, base, cmd)
+		return encodePowershell(fmt.Sprintf(
+			"Invoke-CimMethod -ClassName Win32_Process -MethodName Create -Arguments @{CommandLine = %s} | Out-Null",
+			quote("powershell", encodePowershell(script)),
+		))
+	default:
+		script := fmt.Sprintf(detachShell, base, cmd)
+		return fmt.Sprintf(": > %s.log; nohup setsid /bin/sh -c %s < /dev/null > /dev/null 2>&1 &",
+			base, quote("sh", script))
+	}
+}
+
+// helper function returns the remote command that stops the
+// detached process, and all of its children. On posix systems
+// the process group is terminated, and killed if it does not
+// exit within ten seconds.
+func stopScript(os, pidfile string) string {
+	switch os {
+	case "windows":
+		return encodePowershell(fmt.Sprintf("taskkill /F /T /PID (Get-Content %s)", pidfile))
+	default:
+		return fmt.Sprintf(stopShell, pidfile)
+	}
+}
+
+// helper function returns the remote command that creates
+// a compressed archive of the paths, relative to the directory.
+func archiveScript(os, archive, dir string, paths []string) string {
+	shell := "sh"
+	if os == "windows" {
+		shell = "powershell"
+	}
+	var args []string
+	for _, p := range paths {
+		args = append(args, quote(shell, p))
+	}
+	script := fmt.Sprintf("tar -czf %s -C %s %s",
+		quote(shell, archive),
+		quote(shell, dir),
+		strings.Join(args, " "),
+	)
+	if os == "windows" {
+		script = script + "; exit $LASTEXITCODE"
+	}
+	return script
+}
+
+// helper function returns the remote command that extracts
+// the compressed archive to the directory, and removes the
+// archive.
+func extractScript(os, archive, dir string) string {
+	switch os {
+	case "windows":
+		return fmt.Sprintf("tar -xzf %[1]s -C %[2]s; $code = $LASTEXITCODE; Remove-Item -Force %[1]s; exit $code",
+			quote("powershell", archive),
+			quote("powershell", dir),
+		)
+	default:
+		return fmt.Sprintf("tar -xzf %[1]s -C %[2]s; code=$?; rm -f %[1]s; exit $code",
+			quote("sh", archive),
+			quote("sh", dir),
+		)
+	}
+}
+
+// helper function returns the remote command that lists the
+// files matching the glob patterns, relative to the directory.
+// Directories are listed recursively.
+func listScript(os, dir string, patterns []string) string {
+	switch os {
+	case "windows":
+		var args []string
+		for _, p := range patterns {
+			args = append(args, quote("powershell", p))
+		}
+		return fmt.Sprintf(listPowershell, quote("powershell", dir), strings.Join(args, ", "))
+	default:
+		return fmt.Sprintf(listShell, quote("sh", dir), strings.Join(patterns, " "))
+	}
+}
+
+// helper function returns the remote command that removes
+// the file.
+func removeScript(os, path string) string {
+	switch os {
+	case "windows":
+		return fmt.Sprintf("Remove-Item -Force %s", quote("powershell", path))
+	default:
+		return fmt.Sprintf("rm -f %s", quote("sh", path))
+	}
+}
+
+// helper function returns the command that executes the
+// powershell script as an encoded command, which can be
+// executed from any windows shell without quoting.
+func encodePowershell(script string) string {
+	var buf bytes.Buffer
+	for _, r := range utf16.Encode([]rune(script)) {
+		buf.WriteByte(byte(r))
+		buf.WriteByte(byte(r >> 8))
+	}
+	return "powershell -NoProfile -NonInteractive -EncodedCommand " +
+		base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+const detachShell = `echo $$ > %[1]s.pid
+%[2]s > %[1]s.log 2>&1
+echo $? > %[1]s.tmp
+mv %[1]s.tmp %[1]s.exit`
+
+const detachPowershell = `$PID | Out-File -Encoding ascii %[1]s.pid
+& %[2]s 2>&1 | ForEach-Object { [IO.File]::AppendAllText('%[1]s.log', $_.ToString() + [Environment]::NewLine) }
+$LASTEXITCODE | Out-File -Encoding ascii %[1]s.tmp
+Move-Item -Force %[1]s.tmp %[1]s.exit`
+
+const stopShell = `pgid=$(cat %s)
+kill -TERM -$pgid 2>/dev/null
+for i in 1 2 3 4 5 6 7 8 9 10; do
+  kill -0 -$pgid 2>/dev/null || exit 0
+  sleep 1
+done
+kill -KILL -$pgid 2>/dev/null
+exit 0`
+
+// helper function returns the shell used to execute the step,
+// defaulting to the platform shell.
+func getShell(os string, step *Step) string {
+	switch {
+	case step.Shell != "":
+		return step.Shell
+	case os == "windows":
+		return "powershell"
+	default:
+		return "sh"
+	}
+}
+
+// helper function returns the path in the format expected by
+// the remote sftp server. The windows sftp server expects
+// forward slashes.
+func remotePath(os, path string) string {
+	switch os {
+	case "windows":
+		return strings.Replace(path, "\\", "/", -1)
+	default:
+		return path
+	}
+}
+
+// helper function trims the key type and trailing newline
+// from an authorized key, returning the base64 encoded key.
+func trimPublicKey(key string) string {
+	key = strings.TrimSpace(key)
+	return strings.TrimPrefix(key, "ssh-rsa ")
+}
+
+// helper function generates a random password that satisfies
+// the default windows password complexity requirements.
+func generatePassword() string {
+	return "Dr0ne!" + uniuri.NewLen(24)
+}
+
+// helper function writes the file to the remote server and then
+// configures the file permissions.
+func upload(client *sftp.Client, path string, data []byte, mode uint32) error {
+	f, err := client.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Chmod(os.FileMode(mode))
+}
+
+// helper function creates the folder on the remote server and
+// then configures the folder permissions.
+func mkdir(client *sftp.Client, path string, mode uint32) error {
+	err := client.MkdirAll(path)
+	if err != nil {
+		return err
+	}
+	return client.Chmod(path, os.FileMode(mode))
+}
+
+const listShell = `cd %s || exit 1
+for f in %s; do
+  if [ -d "$f" ]; then find "$f" -type f; elif [ -f "$f" ]; then echo "$f"; fi
+done
+exit 0`
+
+const listPowershell = `Set-Location -LiteralPath %s
+Get-ChildItem -Path %s -Recurse -File -ErrorAction SilentlyContinue | Resolve-Path -Relative`
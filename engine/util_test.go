@@ -0,0 +1,108 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_writeEnv(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  string
+	}{
+		{shell: "sh", want: "export GREETING='it'\"'\"'s $HOME'\n"},
+		{shell: "bash", want: "export GREETING='it'\"'\"'s $HOME'\n"},
+		{shell: "powershell", want: "$Env:GREETING = 'it''s $HOME'\n"},
+		{shell: "pwsh", want: "$Env:GREETING = 'it''s $HOME'\n"},
+		{shell: "cmd", want: "set GREETING=it's $HOME\n"},
+		{shell: "python", want: "os.environ[\"GREETING\"] = \"it's $HOME\"\n"},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		writeEnv(buf, test.shell, "GREETING", "it's $HOME")
+		if got := buf.String(); got != test.want {
+			t.Errorf("Want %q for shell %s, got %q", test.want, test.shell, got)
+		}
+	}
+}
+
+// This test verifies values written for the cmd shell are
+// not expanded or interpreted.
+func Test_writeEnv_Cmd(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "100%", want: "set GREETING=100%%\n"},
+		{value: "%PATH%", want: "set GREETING=%%PATH%%\n"},
+		{value: `say "hi"`, want: "set GREETING=say ^\"hi^\"\n"},
+		{value: "a & calc.exe", want: "set GREETING=a ^& calc.exe\n"},
+		{value: `"&calc&"`, want: "set GREETING=^\"^&calc^&^\"\n"},
+		{value: "a|b>c<d^(e)", want: "set GREETING=a^|b^>c^<d^^^(e^)\n"},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		writeEnv(buf, "cmd", "GREETING", test.value)
+		if got := buf.String(); got != test.want {
+			t.Errorf("Want %q for value %q, got %q", test.want, test.value, got)
+		}
+	}
+}
+
+func Test_checkEnv(t *testing.T) {
+	step := &Step{
+		Envs:    map[string]string{"GREETING": "hello"},
+		Secrets: []*Secret{{Env: "PASSWORD", Data: []byte("line1\nline2")}},
+	}
+	if err := checkEnv("sh", step); err != nil {
+		t.Errorf("Expect line breaks allowed for sh, got %s", err)
+	}
+	if err := checkEnv("cmd", step); err == nil {
+		t.Errorf("Expect line breaks rejected for cmd")
+	}
+	step.Secrets[0].Data = []byte("100%")
+	if err := checkEnv("cmd", step); err != nil {
+		t.Errorf("Expect no error, got %s", err)
+	}
+}
+
+func Test_writeWorkdir(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  string
+	}{
+		{shell: "sh", want: "cd '/tmp/drone/src'\n"},
+		{shell: "pwsh", want: "cd '/tmp/drone/src'\n"},
+		{shell: "cmd", want: "cd /d \"/tmp/drone/src\"\n"},
+		{shell: "python", want: "os.chdir(\"/tmp/drone/src\")\n"},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		writeWorkdir(buf, test.shell, "/tmp/drone/src")
+		if got := buf.String(); got != test.want {
+			t.Errorf("Want %q for shell %s, got %q", test.want, test.shell, got)
+		}
+	}
+}
+
+func Test_writeHeader(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  string
+	}{
+		{shell: "sh", want: ""},
+		{shell: "cmd", want: "@echo off\n"},
+		{shell: "python", want: "import os\n"},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		writeHeader(buf, test.shell)
+		if got := buf.String(); got != test.want {
+			t.Errorf("Want %q for shell %s, got %q", test.want, test.shell, got)
+		}
+	}
+}
@@ -22,8 +22,9 @@ require (
 	github.com/kr/pretty v0.2.0
 	github.com/mattn/go-isatty v0.0.8
 	github.com/natessilva/dag v0.0.0-20180124060714-7194b8dcc5c4
+	github.com/pkg/sftp v1.11.0
 	github.com/sirupsen/logrus v1.4.2
-	golang.org/x/crypto v0.0.0-20190621222207-cc06ce4a13d4
+	golang.org/x/crypto v0.0.0-20190820162420-60c769a6c586
 	golang.org/x/sync v0.0.0-20190423024810-112230192c58
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 )
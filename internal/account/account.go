@@ -0,0 +1,108 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package account provides named amazon accounts, configured
+// by the runner, that pipelines reference by name instead of
+// defining the account credentials.
+package account
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/buildkite/yaml"
+)
+
+// Account defines a named amazon account.
+type Account struct {
+	// Name is the account name referenced by the pipeline.
+	Name string `yaml:"name"`
+
+	// AccessKeyID and AccessKeySecret provide the account
+	// credentials. If empty, the default credential chain
+	// of the runner is used.
+	AccessKeyID     string `yaml:"access_key_id"`
+	AccessKeySecret string `yaml:"secret_access_key"`
+
+	// RoleARN is the optional role assumed using the account
+	// credentials, with the optional external id.
+	RoleARN    string `yaml:"role_arn"`
+	ExternalID string `yaml:"external_id"`
+
+	// Region is the default account region.
+	Region string `yaml:"region"`
+
+	// Repos lists the repositories allowed to use the
+	// account. Values can be glob patterns. If empty, all
+	// repositories are allowed.
+	Repos []string `yaml:"repos"`
+}
+
+// Allowed returns true if the repository, identified by its
+// slug, is allowed to use the account.
+func (a *Account) Allowed(slug string) bool {
+	if len(a.Repos) == 0 {
+		return true
+	}
+	for _, pattern := range a.Repos {
+		if ok, _ := filepath.Match(pattern, slug); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Accounts provides the named accounts, indexed by name.
+type Accounts map[string]*Account
+
+// Lookup returns the named account.
+func (a Accounts) Lookup(name string) (*Account, bool) {
+	account, ok := a[name]
+	return account, ok
+}
+
+// Load loads the accounts from the yaml file.
+func Load(path string) (Accounts, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(raw)
+}
+
+// Parse parses the accounts from the yaml document.
+func Parse(data []byte) (Accounts, error) {
+	var file struct {
+		Accounts []*Account `yaml:"accounts"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	return New(file.Accounts)
+}
+
+// New returns the named accounts, indexed by name, and
+// returns an error if an account is invalid.
+func New(list []*Account) (Accounts, error) {
+	accounts := Accounts{}
+	for _, account := range list {
+		switch {
+		case account == nil || account.Name == "":
+			return nil, errors.New("account: missing account name")
+		case accounts[account.Name] != nil:
+			return nil, fmt.Errorf("account: duplicate account %s", account.Name)
+		case (account.AccessKeyID == "") != (account.AccessKeySecret == ""):
+			return nil, fmt.Errorf("account: account %s requires both the access key id and secret", account.Name)
+		}
+		for _, pattern := range account.Repos {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("account: account %s has an invalid repository pattern %s", account.Name, pattern)
+			}
+		}
+		accounts[account.Name] = account
+	}
+	return accounts, nil
+}
@@ -0,0 +1,255 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package awssecret provides a secret provider that sources
+// secrets from AWS Secrets Manager and the SSM Parameter Store.
+package awssecret
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/secret"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+const (
+	// prefix of secrets sourced from secrets manager, in the
+	// format aws-sm://path#key.
+	prefixSecretsManager = "aws-sm://"
+
+	// prefix of secrets sourced from the parameter store, in
+	// the format ssm:///path.
+	prefixParameterStore = "ssm://"
+)
+
+// Config configures the secret provider.
+type Config struct {
+	// Region is the region of the secrets.
+	Region string
+
+	// Endpoint overrides the default endpoint, and is used to
+	// connect to a compatible server.
+	Endpoint string
+
+	// TTL is the duration for which secret values are cached.
+	// Secret values are not cached if the TTL is zero.
+	TTL time.Duration
+
+	// Allow is the list of path prefixes each repository can
+	// access, in the format repository=prefix. The repository
+	// may be a glob pattern. Secrets are not accessible unless
+	// the path matches a prefix allowed for the repository.
+	Allow []string
+}
+
+// New returns a new secret provider that sources secrets from
+// AWS Secrets Manager and the SSM Parameter Store, using the
+// default credential chain of the runner.
+func New(config Config) secret.Provider {
+	awsconfig := aws.NewConfig().WithRegion(config.Region)
+	if config.Endpoint != "" {
+		awsconfig = awsconfig.WithEndpoint(config.Endpoint)
+	}
+	sess := session.New(awsconfig)
+	return &provider{
+		config:  config,
+		secrets: secretsmanager.New(sess),
+		params:  ssm.New(sess),
+		cache:   map[string]entry{},
+	}
+}
+
+type provider struct {
+	config  Config
+	secrets *secretsmanager.SecretsManager
+	params  *ssm.SSM
+
+	mu    sync.Mutex
+	cache map[string]entry
+}
+
+type entry struct {
+	value   string
+	expires time.Time
+}
+
+// Find finds and returns the named secret. Secret names that
+// do not reference Secrets Manager or the Parameter Store are
+// ignored, so that the secret can be sourced from the next
+// provider.
+func (p *provider) Find(ctx context.Context, in *secret.Request) (*drone.Secret, error) {
+	var path, key string
+	var get func(context.Context, string) (string, bool, error)
+	switch {
+	case strings.HasPrefix(in.Name, prefixSecretsManager):
+		path = strings.TrimPrefix(in.Name, prefixSecretsManager)
+		if i := strings.Index(path, "#"); i != -1 {
+			path, key = path[:i], path[i+1:]
+		}
+		get = p.getSecret
+	case strings.HasPrefix(in.Name, prefixParameterStore):
+		path = strings.TrimPrefix(in.Name, prefixParameterStore)
+		get = p.getParameter
+	default:
+		return nil, nil
+	}
+
+	if in.Repo == nil {
+		return nil, nil
+	}
+
+	log := logger.FromContext(ctx).
+		WithField("name", in.Name).
+		WithField("repo", in.Repo.Slug)
+
+	// secrets are not exposed to pull requests from forks,
+	// consistent with repository secrets.
+	if in.Build != nil && in.Build.Fork != "" && in.Build.Fork != in.Repo.Slug {
+		log.Debug("secret not exposed to forks")
+		return nil, nil
+	}
+	if !allowed(p.config.Allow, in.Repo.Slug, path) {
+		log.Warn("secret path not allowed for repository")
+		return nil, nil
+	}
+
+	// secret values are cached by source and path, so that
+	// json keys of the same secret share the cached value.
+	id := strings.TrimSuffix(in.Name, "#"+key)
+	value, ok, err := p.find(ctx, id, path, get)
+	if err != nil {
+		log.WithError(err).Warn("cannot find secret")
+		return nil, err
+	}
+	if !ok {
+		log.Debug("secret not found")
+		return nil, nil
+	}
+
+	// secrets manager secrets may store a json object, in
+	// which case the key selects the value.
+	if key != "" {
+		values := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(value), &values); err != nil {
+			return nil, fmt.Errorf("secret %s is not a json object", path)
+		}
+		v, ok := values[key]
+		if !ok {
+			log.Debug("secret key not found")
+			return nil, nil
+		}
+		if s, ok := v.(string); ok {
+			value = s
+		} else {
+			value = fmt.Sprint(v)
+		}
+	}
+	return &drone.Secret{
+		Name: in.Name,
+		Data: value,
+	}, nil
+}
+
+// helper function returns the secret value from the cache, or
+// from the source if the cached value expired.
+func (p *provider) find(ctx context.Context, id, path string, get func(context.Context, string) (string, bool, error)) (string, bool, error) {
+	if p.config.TTL > 0 {
+		p.mu.Lock()
+		cached, ok := p.cache[id]
+		p.mu.Unlock()
+		if ok && time.Now().Before(cached.expires) {
+			return cached.value, true, nil
+		}
+	}
+	value, ok, err := get(ctx, path)
+	if err != nil || !ok {
+		return "", ok, err
+	}
+	if p.config.TTL > 0 {
+		p.mu.Lock()
+		p.cache[id] = entry{
+			value:   value,
+			expires: time.Now().Add(p.config.TTL),
+		}
+		p.mu.Unlock()
+	}
+	return value, true, nil
+}
+
+// helper function returns the secret value from secrets
+// manager.
+func (p *provider) getSecret(ctx context.Context, path string) (string, bool, error) {
+	out, err := p.secrets.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(path),
+	})
+	if isNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return aws.StringValue(out.SecretString), true, nil
+}
+
+// helper function returns the decrypted parameter value from
+// the parameter store.
+func (p *provider) getParameter(ctx context.Context, path string) (string, bool, error) {
+	out, err := p.params.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(path),
+		WithDecryption: aws.Bool(true),
+	})
+	if isNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return aws.StringValue(out.Parameter.Value), true, nil
+}
+
+// helper function returns true if the error indicates the
+// secret or parameter does not exist.
+func isNotFound(err error) bool {
+	if err, ok := err.(awserr.Error); ok {
+		switch err.Code() {
+		case secretsmanager.ErrCodeResourceNotFoundException,
+			ssm.ErrCodeParameterNotFound:
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if the path matches a prefix
+// allowed for the repository. The prefix matches whole path
+// segments, so that prefix /team-a does not match /team-ab.
+func allowed(allow []string, repo, path string) bool {
+	for _, rule := range allow {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(parts[0], repo); !ok {
+			continue
+		}
+		prefix := parts[1]
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
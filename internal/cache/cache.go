@@ -0,0 +1,192 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package cache contains code to save and restore pipeline
+// cache archives and artifacts in an S3 bucket.
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Credentials provides the bucket credentials.
+type Credentials struct {
+	Client string
+	Secret string
+	Region string
+
+	// RoleARN is the optional role assumed using the
+	// credentials, with the optional external id.
+	RoleARN    string
+	ExternalID string
+
+	// Endpoint overrides the default S3 endpoint, and is
+	// used to connect to an S3-compatible server.
+	Endpoint string
+}
+
+// Upload uploads the cache archive, read from the reader, to
+// the bucket. The archive is uploaded in parts, and is never
+// fully buffered in memory.
+func Upload(ctx context.Context, creds Credentials, bucket, key string, r io.Reader) error {
+	uploader := s3manager.NewUploaderWithClient(getClient(creds))
+	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   r,
+	})
+	return err
+}
+
+// Download downloads the cache archive from the bucket and
+// writes the archive to the writer. The archive is downloaded
+// in parts, and is never fully buffered in memory. It returns
+// the archive size.
+func Download(ctx context.Context, creds Credentials, bucket, key string, w io.Writer) (int64, error) {
+	downloader := s3manager.NewDownloaderWithClient(getClient(creds), func(d *s3manager.Downloader) {
+		// the parts are downloaded one at a time, so that
+		// they are written to the writer in order.
+		d.Concurrency = 1
+	})
+	return downloader.DownloadWithContext(ctx, &sequentialWriter{w: w}, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+}
+
+// Presign returns a link to download the object from the
+// bucket, which expires after the duration.
+func Presign(creds Credentials, bucket, key string, expires time.Duration) (string, error) {
+	client := getClient(creds)
+	req, _ := client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return req.Presign(expires)
+}
+
+// Exists returns true if the cache archive exists in the
+// bucket.
+func Exists(ctx context.Context, creds Credentials, bucket, key string) (bool, error) {
+	client := getClient(creds)
+	_, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Find returns the key of the cache archive to restore. The key
+// is matched exactly, and the restore keys are matched as
+// prefixes, in order, returning the most recently modified
+// archive. An empty key is returned if no archive matches.
+func Find(ctx context.Context, creds Credentials, bucket, key string, restoreKeys []string) (string, error) {
+	ok, err := Exists(ctx, creds, bucket, key)
+	if err != nil || ok {
+		return key, err
+	}
+	client := getClient(creds)
+	for _, prefix := range restoreKeys {
+		var latest *s3.Object
+		err := client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(prefix),
+		}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+			for _, object := range page.Contents {
+				if latest == nil || aws.TimeValue(object.LastModified).After(aws.TimeValue(latest.LastModified)) {
+					latest = object
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return "", err
+		}
+		if latest != nil {
+			return aws.StringValue(latest.Key), nil
+		}
+	}
+	return "", nil
+}
+
+// sequentialWriter adapts the writer to the io.WriterAt
+// interface required by the downloader. The downloader writes
+// the parts in order, but rewrites a part from the start when
+// reading the part is retried, in which case the bytes already
+// written are skipped.
+type sequentialWriter struct {
+	w   io.Writer
+	pos int64
+}
+
+func (s *sequentialWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off > s.pos {
+		return 0, errors.New("cache: out of order write")
+	}
+	skip := s.pos - off
+	if skip >= int64(len(p)) {
+		return len(p), nil
+	}
+	n, err := s.w.Write(p[skip:])
+	s.pos += int64(n)
+	return int(skip) + n, err
+}
+
+// helper function returns true if the error indicates the
+// object does not exist.
+func isNotFound(err error) bool {
+	if err, ok := err.(awserr.RequestFailure); ok {
+		return err.StatusCode() == http.StatusNotFound
+	}
+	return false
+}
+
+// helper function returns an s3 client.
+func getClient(creds Credentials) *s3.S3 {
+	config := aws.NewConfig()
+	config = config.WithRegion(creds.Region)
+	config = config.WithMaxRetries(10)
+	// if the credentials are not provided the default
+	// credential chain is used, which includes the instance
+	// profile of the runner.
+	if creds.Client != "" {
+		config = config.WithCredentials(
+			credentials.NewStaticCredentials(creds.Client, creds.Secret, ""),
+		)
+	}
+	// S3-compatible servers are typically addressed by path
+	// rather than by virtual host.
+	if creds.Endpoint != "" {
+		config = config.WithEndpoint(creds.Endpoint)
+		config = config.WithS3ForcePathStyle(true)
+	}
+	sess := session.New(config)
+	// if a role is provided, the role is assumed using the
+	// above credentials.
+	if creds.RoleARN != "" {
+		return s3.New(sess, aws.NewConfig().WithCredentials(
+			stscreds.NewCredentials(sess, creds.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+				if creds.ExternalID != "" {
+					p.ExternalID = aws.String(creds.ExternalID)
+				}
+			}),
+		))
+	}
+	return s3.New(sess)
+}
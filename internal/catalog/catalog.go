@@ -0,0 +1,169 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package catalog provides the embedded catalog of amazon
+// instance types, used to validate the instance settings
+// before the instance is provisioned.
+package catalog
+
+//go:generate go run ../../main.go catalog --output catalog_data.go
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// InstanceType describes an amazon instance type.
+type InstanceType struct {
+	// Name is the instance type name (e.g. t3.nano).
+	Name string
+
+	// VCPU is the default number of virtual cpus.
+	VCPU int64
+
+	// Memory is the memory size in MiB.
+	Memory int64
+
+	// Arch lists the supported architectures, using the
+	// amazon architecture names (e.g. x86_64, arm64).
+	Arch []string
+}
+
+// SupportsArch returns true if the instance type supports the
+// platform architecture (e.g. amd64, arm64). The default
+// architecture is amd64.
+func (t InstanceType) SupportsArch(arch string) bool {
+	switch arch {
+	case "", "amd64":
+		arch = "x86_64"
+	case "386":
+		arch = "i386"
+	}
+	for _, s := range t.Arch {
+		if s == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// index of the embedded instance types by name, and of the
+// instance families (e.g. t3) in the embedded catalog.
+var (
+	index    = map[string]InstanceType{}
+	families = map[string]bool{}
+)
+
+func init() {
+	for _, t := range instanceTypes {
+		index[t.Name] = t
+		families[Family(t.Name)] = true
+	}
+}
+
+// Family returns the instance family of the named instance
+// type (e.g. t3 for t3.nano).
+func Family(name string) string {
+	if i := strings.Index(name, "."); i != -1 {
+		return name[:i]
+	}
+	return name
+}
+
+// HasFamily returns true if the embedded catalog includes the
+// instance family.
+func HasFamily(family string) bool {
+	return families[family]
+}
+
+// Lookup returns the named instance type from the embedded
+// catalog.
+func Lookup(name string) (InstanceType, bool) {
+	t, ok := index[name]
+	return t, ok
+}
+
+// All returns the instance types in the embedded catalog,
+// sorted by name.
+func All() []InstanceType {
+	out := make([]InstanceType, len(instanceTypes))
+	copy(out, instanceTypes)
+	return out
+}
+
+// Fetch returns the instance types offered in the region,
+// sorted by name, using the default credential chain. The
+// endpoint is optional, and overrides the default endpoint.
+func Fetch(ctx context.Context, region, endpoint string) ([]InstanceType, error) {
+	config := aws.NewConfig().WithRegion(region)
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+	client := ec2.New(session.New(config))
+	var out []InstanceType
+	err := client.DescribeInstanceTypesPagesWithContext(ctx, new(ec2.DescribeInstanceTypesInput),
+		func(page *ec2.DescribeInstanceTypesOutput, last bool) bool {
+			for _, info := range page.InstanceTypes {
+				out = append(out, convert(info))
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+// Generate returns the go source code of the embedded catalog
+// for the instance types.
+func Generate(types []InstanceType) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(header)
+	for _, t := range types {
+		fmt.Fprintf(buf, "\t{Name: %q, VCPU: %d, Memory: %d, Arch: %#v},\n",
+			t.Name, t.VCPU, t.Memory, t.Arch)
+	}
+	buf.WriteString("}\n")
+	return format.Source(buf.Bytes())
+}
+
+const header = `// Code generated by drone-runner-aws catalog. DO NOT EDIT.
+
+package catalog
+
+// instanceTypes is the embedded catalog of instance types,
+// sorted by name. The catalog is refreshed with the catalog
+// command, which queries DescribeInstanceTypes.
+var instanceTypes = []InstanceType{
+`
+
+// helper function converts the amazon instance type
+// information to the catalog instance type.
+func convert(info *ec2.InstanceTypeInfo) InstanceType {
+	t := InstanceType{
+		Name: aws.StringValue(info.InstanceType),
+	}
+	if info.VCpuInfo != nil {
+		t.VCPU = aws.Int64Value(info.VCpuInfo.DefaultVCpus)
+	}
+	if info.MemoryInfo != nil {
+		t.Memory = aws.Int64Value(info.MemoryInfo.SizeInMiB)
+	}
+	if info.ProcessorInfo != nil {
+		t.Arch = aws.StringValueSlice(info.ProcessorInfo.SupportedArchitectures)
+	}
+	return t
+}
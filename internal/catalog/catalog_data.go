@@ -0,0 +1,127 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package catalog
+
+// instanceTypes is the embedded catalog of instance types,
+// sorted by name. The catalog lists the common instance
+// families, and can be replaced with the full list of
+// instance types using the catalog command, which queries
+// DescribeInstanceTypes.
+var instanceTypes = []InstanceType{
+	{Name: "a1.2xlarge", VCPU: 8, Memory: 16384, Arch: []string{"arm64"}},
+	{Name: "a1.4xlarge", VCPU: 16, Memory: 32768, Arch: []string{"arm64"}},
+	{Name: "a1.large", VCPU: 2, Memory: 4096, Arch: []string{"arm64"}},
+	{Name: "a1.medium", VCPU: 1, Memory: 2048, Arch: []string{"arm64"}},
+	{Name: "a1.metal", VCPU: 16, Memory: 32768, Arch: []string{"arm64"}},
+	{Name: "a1.xlarge", VCPU: 4, Memory: 8192, Arch: []string{"arm64"}},
+	{Name: "c5.12xlarge", VCPU: 48, Memory: 98304, Arch: []string{"x86_64"}},
+	{Name: "c5.18xlarge", VCPU: 72, Memory: 147456, Arch: []string{"x86_64"}},
+	{Name: "c5.24xlarge", VCPU: 96, Memory: 196608, Arch: []string{"x86_64"}},
+	{Name: "c5.2xlarge", VCPU: 8, Memory: 16384, Arch: []string{"x86_64"}},
+	{Name: "c5.4xlarge", VCPU: 16, Memory: 32768, Arch: []string{"x86_64"}},
+	{Name: "c5.9xlarge", VCPU: 36, Memory: 73728, Arch: []string{"x86_64"}},
+	{Name: "c5.large", VCPU: 2, Memory: 4096, Arch: []string{"x86_64"}},
+	{Name: "c5.metal", VCPU: 96, Memory: 196608, Arch: []string{"x86_64"}},
+	{Name: "c5.xlarge", VCPU: 4, Memory: 8192, Arch: []string{"x86_64"}},
+	{Name: "c5d.12xlarge", VCPU: 48, Memory: 98304, Arch: []string{"x86_64"}},
+	{Name: "c5d.18xlarge", VCPU: 72, Memory: 147456, Arch: []string{"x86_64"}},
+	{Name: "c5d.24xlarge", VCPU: 96, Memory: 196608, Arch: []string{"x86_64"}},
+	{Name: "c5d.2xlarge", VCPU: 8, Memory: 16384, Arch: []string{"x86_64"}},
+	{Name: "c5d.4xlarge", VCPU: 16, Memory: 32768, Arch: []string{"x86_64"}},
+	{Name: "c5d.9xlarge", VCPU: 36, Memory: 73728, Arch: []string{"x86_64"}},
+	{Name: "c5d.large", VCPU: 2, Memory: 4096, Arch: []string{"x86_64"}},
+	{Name: "c5d.metal", VCPU: 96, Memory: 196608, Arch: []string{"x86_64"}},
+	{Name: "c5d.xlarge", VCPU: 4, Memory: 8192, Arch: []string{"x86_64"}},
+	{Name: "c6g.12xlarge", VCPU: 48, Memory: 98304, Arch: []string{"arm64"}},
+	{Name: "c6g.16xlarge", VCPU: 64, Memory: 131072, Arch: []string{"arm64"}},
+	{Name: "c6g.2xlarge", VCPU: 8, Memory: 16384, Arch: []string{"arm64"}},
+	{Name: "c6g.4xlarge", VCPU: 16, Memory: 32768, Arch: []string{"arm64"}},
+	{Name: "c6g.8xlarge", VCPU: 32, Memory: 65536, Arch: []string{"arm64"}},
+	{Name: "c6g.large", VCPU: 2, Memory: 4096, Arch: []string{"arm64"}},
+	{Name: "c6g.medium", VCPU: 1, Memory: 2048, Arch: []string{"arm64"}},
+	{Name: "c6g.metal", VCPU: 64, Memory: 131072, Arch: []string{"arm64"}},
+	{Name: "c6g.xlarge", VCPU: 4, Memory: 8192, Arch: []string{"arm64"}},
+	{Name: "m5.12xlarge", VCPU: 48, Memory: 196608, Arch: []string{"x86_64"}},
+	{Name: "m5.16xlarge", VCPU: 64, Memory: 262144, Arch: []string{"x86_64"}},
+	{Name: "m5.24xlarge", VCPU: 96, Memory: 393216, Arch: []string{"x86_64"}},
+	{Name: "m5.2xlarge", VCPU: 8, Memory: 32768, Arch: []string{"x86_64"}},
+	{Name: "m5.4xlarge", VCPU: 16, Memory: 65536, Arch: []string{"x86_64"}},
+	{Name: "m5.8xlarge", VCPU: 32, Memory: 131072, Arch: []string{"x86_64"}},
+	{Name: "m5.large", VCPU: 2, Memory: 8192, Arch: []string{"x86_64"}},
+	{Name: "m5.metal", VCPU: 96, Memory: 393216, Arch: []string{"x86_64"}},
+	{Name: "m5.xlarge", VCPU: 4, Memory: 16384, Arch: []string{"x86_64"}},
+	{Name: "m5a.12xlarge", VCPU: 48, Memory: 196608, Arch: []string{"x86_64"}},
+	{Name: "m5a.16xlarge", VCPU: 64, Memory: 262144, Arch: []string{"x86_64"}},
+	{Name: "m5a.24xlarge", VCPU: 96, Memory: 393216, Arch: []string{"x86_64"}},
+	{Name: "m5a.2xlarge", VCPU: 8, Memory: 32768, Arch: []string{"x86_64"}},
+	{Name: "m5a.4xlarge", VCPU: 16, Memory: 65536, Arch: []string{"x86_64"}},
+	{Name: "m5a.8xlarge", VCPU: 32, Memory: 131072, Arch: []string{"x86_64"}},
+	{Name: "m5a.large", VCPU: 2, Memory: 8192, Arch: []string{"x86_64"}},
+	{Name: "m5a.xlarge", VCPU: 4, Memory: 16384, Arch: []string{"x86_64"}},
+	{Name: "m5d.12xlarge", VCPU: 48, Memory: 196608, Arch: []string{"x86_64"}},
+	{Name: "m5d.16xlarge", VCPU: 64, Memory: 262144, Arch: []string{"x86_64"}},
+	{Name: "m5d.24xlarge", VCPU: 96, Memory: 393216, Arch: []string{"x86_64"}},
+	{Name: "m5d.2xlarge", VCPU: 8, Memory: 32768, Arch: []string{"x86_64"}},
+	{Name: "m5d.4xlarge", VCPU: 16, Memory: 65536, Arch: []string{"x86_64"}},
+	{Name: "m5d.8xlarge", VCPU: 32, Memory: 131072, Arch: []string{"x86_64"}},
+	{Name: "m5d.large", VCPU: 2, Memory: 8192, Arch: []string{"x86_64"}},
+	{Name: "m5d.metal", VCPU: 96, Memory: 393216, Arch: []string{"x86_64"}},
+	{Name: "m5d.xlarge", VCPU: 4, Memory: 16384, Arch: []string{"x86_64"}},
+	{Name: "m6g.12xlarge", VCPU: 48, Memory: 196608, Arch: []string{"arm64"}},
+	{Name: "m6g.16xlarge", VCPU: 64, Memory: 262144, Arch: []string{"arm64"}},
+	{Name: "m6g.2xlarge", VCPU: 8, Memory: 32768, Arch: []string{"arm64"}},
+	{Name: "m6g.4xlarge", VCPU: 16, Memory: 65536, Arch: []string{"arm64"}},
+	{Name: "m6g.8xlarge", VCPU: 32, Memory: 131072, Arch: []string{"arm64"}},
+	{Name: "m6g.large", VCPU: 2, Memory: 8192, Arch: []string{"arm64"}},
+	{Name: "m6g.medium", VCPU: 1, Memory: 4096, Arch: []string{"arm64"}},
+	{Name: "m6g.metal", VCPU: 64, Memory: 262144, Arch: []string{"arm64"}},
+	{Name: "m6g.xlarge", VCPU: 4, Memory: 16384, Arch: []string{"arm64"}},
+	{Name: "r5.12xlarge", VCPU: 48, Memory: 393216, Arch: []string{"x86_64"}},
+	{Name: "r5.16xlarge", VCPU: 64, Memory: 524288, Arch: []string{"x86_64"}},
+	{Name: "r5.24xlarge", VCPU: 96, Memory: 786432, Arch: []string{"x86_64"}},
+	{Name: "r5.2xlarge", VCPU: 8, Memory: 65536, Arch: []string{"x86_64"}},
+	{Name: "r5.4xlarge", VCPU: 16, Memory: 131072, Arch: []string{"x86_64"}},
+	{Name: "r5.8xlarge", VCPU: 32, Memory: 262144, Arch: []string{"x86_64"}},
+	{Name: "r5.large", VCPU: 2, Memory: 16384, Arch: []string{"x86_64"}},
+	{Name: "r5.metal", VCPU: 96, Memory: 786432, Arch: []string{"x86_64"}},
+	{Name: "r5.xlarge", VCPU: 4, Memory: 32768, Arch: []string{"x86_64"}},
+	{Name: "r6g.12xlarge", VCPU: 48, Memory: 393216, Arch: []string{"arm64"}},
+	{Name: "r6g.16xlarge", VCPU: 64, Memory: 524288, Arch: []string{"arm64"}},
+	{Name: "r6g.2xlarge", VCPU: 8, Memory: 65536, Arch: []string{"arm64"}},
+	{Name: "r6g.4xlarge", VCPU: 16, Memory: 131072, Arch: []string{"arm64"}},
+	{Name: "r6g.8xlarge", VCPU: 32, Memory: 262144, Arch: []string{"arm64"}},
+	{Name: "r6g.large", VCPU: 2, Memory: 16384, Arch: []string{"arm64"}},
+	{Name: "r6g.medium", VCPU: 1, Memory: 8192, Arch: []string{"arm64"}},
+	{Name: "r6g.metal", VCPU: 64, Memory: 524288, Arch: []string{"arm64"}},
+	{Name: "r6g.xlarge", VCPU: 4, Memory: 32768, Arch: []string{"arm64"}},
+	{Name: "t2.2xlarge", VCPU: 8, Memory: 32768, Arch: []string{"x86_64"}},
+	{Name: "t2.large", VCPU: 2, Memory: 8192, Arch: []string{"x86_64"}},
+	{Name: "t2.medium", VCPU: 2, Memory: 4096, Arch: []string{"x86_64"}},
+	{Name: "t2.micro", VCPU: 1, Memory: 1024, Arch: []string{"i386", "x86_64"}},
+	{Name: "t2.nano", VCPU: 1, Memory: 512, Arch: []string{"i386", "x86_64"}},
+	{Name: "t2.small", VCPU: 1, Memory: 2048, Arch: []string{"i386", "x86_64"}},
+	{Name: "t2.xlarge", VCPU: 4, Memory: 16384, Arch: []string{"x86_64"}},
+	{Name: "t3.2xlarge", VCPU: 8, Memory: 32768, Arch: []string{"x86_64"}},
+	{Name: "t3.large", VCPU: 2, Memory: 8192, Arch: []string{"x86_64"}},
+	{Name: "t3.medium", VCPU: 2, Memory: 4096, Arch: []string{"x86_64"}},
+	{Name: "t3.micro", VCPU: 2, Memory: 1024, Arch: []string{"x86_64"}},
+	{Name: "t3.nano", VCPU: 2, Memory: 512, Arch: []string{"x86_64"}},
+	{Name: "t3.small", VCPU: 2, Memory: 2048, Arch: []string{"x86_64"}},
+	{Name: "t3.xlarge", VCPU: 4, Memory: 16384, Arch: []string{"x86_64"}},
+	{Name: "t3a.2xlarge", VCPU: 8, Memory: 32768, Arch: []string{"x86_64"}},
+	{Name: "t3a.large", VCPU: 2, Memory: 8192, Arch: []string{"x86_64"}},
+	{Name: "t3a.medium", VCPU: 2, Memory: 4096, Arch: []string{"x86_64"}},
+	{Name: "t3a.micro", VCPU: 2, Memory: 1024, Arch: []string{"x86_64"}},
+	{Name: "t3a.nano", VCPU: 2, Memory: 512, Arch: []string{"x86_64"}},
+	{Name: "t3a.small", VCPU: 2, Memory: 2048, Arch: []string{"x86_64"}},
+	{Name: "t3a.xlarge", VCPU: 4, Memory: 16384, Arch: []string{"x86_64"}},
+	{Name: "t4g.2xlarge", VCPU: 8, Memory: 32768, Arch: []string{"arm64"}},
+	{Name: "t4g.large", VCPU: 2, Memory: 8192, Arch: []string{"arm64"}},
+	{Name: "t4g.medium", VCPU: 2, Memory: 4096, Arch: []string{"arm64"}},
+	{Name: "t4g.micro", VCPU: 2, Memory: 1024, Arch: []string{"arm64"}},
+	{Name: "t4g.nano", VCPU: 2, Memory: 512, Arch: []string{"arm64"}},
+	{Name: "t4g.small", VCPU: 2, Memory: 2048, Arch: []string{"arm64"}},
+	{Name: "t4g.xlarge", VCPU: 4, Memory: 16384, Arch: []string{"arm64"}},
+}
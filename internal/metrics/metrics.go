@@ -0,0 +1,76 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package metrics provides the instance lifecycle and step
+// execution metrics.
+package metrics
+
+import (
+	"context"
+	"time"
+)
+
+// Metrics records the instance lifecycle and step execution
+// metrics.
+type Metrics interface {
+	// InstanceLaunched records an instance launch.
+	InstanceLaunched(instanceType, region string)
+
+	// LaunchFailed records a failed instance launch, with the
+	// amazon error code.
+	LaunchFailed(instanceType, region, code string)
+
+	// InstanceBooted records the duration from the launch until
+	// the instance network address is allocated.
+	InstanceBooted(instanceType, region string, d time.Duration)
+
+	// InstanceReady records the duration from the launch until
+	// the instance accepts ssh or winrm connections.
+	InstanceReady(instanceType, region string, d time.Duration)
+
+	// InstanceTerminated records an instance termination, with
+	// the duration the instance was running.
+	InstanceTerminated(instanceType, region string, uptime time.Duration)
+
+	// TerminateFailed records a failed instance termination,
+	// with the amazon error code. The instance is still
+	// counted as running.
+	TerminateFailed(instanceType, region, code string)
+
+	// StepCompleted records the step duration, with the step
+	// status (success, failure or error).
+	StepCompleted(status string, d time.Duration)
+}
+
+// Nop returns a no-op metrics implementation.
+func Nop() Metrics {
+	return nop{}
+}
+
+type nop struct{}
+
+func (nop) InstanceLaunched(string, string)                  {}
+func (nop) LaunchFailed(string, string, string)              {}
+func (nop) InstanceBooted(string, string, time.Duration)     {}
+func (nop) InstanceReady(string, string, time.Duration)      {}
+func (nop) InstanceTerminated(string, string, time.Duration) {}
+func (nop) TerminateFailed(string, string, string)           {}
+func (nop) StepCompleted(string, time.Duration)              {}
+
+type metricsKey struct{}
+
+// WithContext returns a new context with the metrics.
+func WithContext(ctx context.Context, metrics Metrics) context.Context {
+	return context.WithValue(ctx, metricsKey{}, metrics)
+}
+
+// FromContext returns the metrics from the context. If no
+// metrics are stored in the context, the no-op metrics are
+// returned.
+func FromContext(ctx context.Context) Metrics {
+	if metrics, ok := ctx.Value(metricsKey{}).(Metrics); ok && metrics != nil {
+		return metrics
+	}
+	return nop{}
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFromContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()).(nop); !ok {
+		t.Errorf("Want no-op metrics when the context has no metrics")
+	}
+	m := New(nil)
+	if got := FromContext(WithContext(context.Background(), m)); got != m {
+		t.Errorf("Want metrics from the context")
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	m := New(map[string]float64{"t3.nano": 0.5})
+	m.InstanceLaunched("t3.nano", "us-east-1")
+	m.InstanceLaunched("t3.nano", "us-east-1")
+	m.LaunchFailed("t3.nano", "us-east-1", "InsufficientInstanceCapacity")
+	m.InstanceBooted("t3.nano", "us-east-1", 3*time.Second)
+	m.InstanceReady("t3.nano", "us-east-1", 30*time.Second)
+	m.InstanceTerminated("t3.nano", "us-east-1", 2*time.Hour)
+	m.TerminateFailed("t3.nano", "us-east-1", "UnauthorizedOperation")
+	m.StepCompleted("success", 5*time.Second)
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+
+	for _, want := range []string{
+		`# TYPE drone_aws_instances_launched_total counter`,
+		`drone_aws_instances_launched_total{instance_type="t3.nano",region="us-east-1"} 2`,
+		`drone_aws_instance_launch_failures_total{instance_type="t3.nano",region="us-east-1",code="InsufficientInstanceCapacity"} 1`,
+		`drone_aws_instance_boot_seconds_bucket{instance_type="t3.nano",region="us-east-1",le="2"} 0`,
+		`drone_aws_instance_boot_seconds_bucket{instance_type="t3.nano",region="us-east-1",le="4"} 1`,
+		`drone_aws_instance_boot_seconds_bucket{instance_type="t3.nano",region="us-east-1",le="+Inf"} 1`,
+		`drone_aws_instance_ready_seconds_sum{instance_type="t3.nano",region="us-east-1"} 30`,
+		`drone_aws_instances_terminated_total{instance_type="t3.nano",region="us-east-1"} 1`,
+		`drone_aws_instance_termination_failures_total{instance_type="t3.nano",region="us-east-1",code="UnauthorizedOperation"} 1`,
+		`drone_aws_instances_running{instance_type="t3.nano",region="us-east-1"} 1`,
+		`drone_aws_estimated_cost_dollars_total{instance_type="t3.nano",region="us-east-1"} 1`,
+		`drone_aws_step_duration_seconds_count{status="success"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Want metrics to contain %s", want)
+		}
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Want prometheus text content type")
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durations buckets, in seconds, from one second to about
+// one hour.
+var durationBuckets = []float64{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 2048}
+
+// Prometheus records the metrics and serves them in the
+// prometheus text exposition format.
+type Prometheus struct {
+	mu     sync.Mutex
+	prices map[string]float64
+
+	launched   *vector
+	failed     *vector
+	boot       *vector
+	ready      *vector
+	terminated *vector
+	termFailed *vector
+	running    *vector
+	cost       *vector
+	steps      *vector
+}
+
+// New returns prometheus metrics. The prices provide the
+// hourly price of each instance type, in dollars, used to
+// estimate the cost of the terminated instances. Instance
+// types without a price are not included in the estimate.
+func New(prices map[string]float64) *Prometheus {
+	labels := []string{"instance_type", "region"}
+	return &Prometheus{
+		prices: prices,
+		launched: newVector("counter",
+			"drone_aws_instances_launched_total",
+			"Total number of instances launched.",
+			labels...),
+		failed: newVector("counter",
+			"drone_aws_instance_launch_failures_total",
+			"Total number of failed instance launches, by amazon error code.",
+			"instance_type", "region", "code"),
+		boot: newVector("histogram",
+			"drone_aws_instance_boot_seconds",
+			"Duration from the instance launch until the network address is allocated.",
+			labels...),
+		ready: newVector("histogram",
+			"drone_aws_instance_ready_seconds",
+			"Duration from the instance launch until the instance accepts connections.",
+			labels...),
+		terminated: newVector("counter",
+			"drone_aws_instances_terminated_total",
+			"Total number of instances terminated.",
+			labels...),
+		termFailed: newVector("counter",
+			"drone_aws_instance_termination_failures_total",
+			"Total number of failed instance terminations, by amazon error code.",
+			"instance_type", "region", "code"),
+		running: newVector("gauge",
+			"drone_aws_instances_running",
+			"Number of running instances.",
+			labels...),
+		cost: newVector("counter",
+			"drone_aws_estimated_cost_dollars_total",
+			"Estimated cost of the terminated instances, in dollars. The cost is recorded when the instance is terminated, and excludes the running instances.",
+			labels...),
+		steps: newVector("histogram",
+			"drone_aws_step_duration_seconds",
+			"Duration of the pipeline steps, by status.",
+			"status"),
+	}
+}
+
+// Handler returns an http.Handler that serves the metrics.
+func (m *Prometheus) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(m.expose())
+	})
+}
+
+// InstanceLaunched records an instance launch.
+func (m *Prometheus) InstanceLaunched(instanceType, region string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.launched.add(1, instanceType, region)
+	m.running.add(1, instanceType, region)
+}
+
+// LaunchFailed records a failed instance launch.
+func (m *Prometheus) LaunchFailed(instanceType, region, code string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed.add(1, instanceType, region, code)
+}
+
+// InstanceBooted records the instance boot duration.
+func (m *Prometheus) InstanceBooted(instanceType, region string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.boot.observe(d.Seconds(), instanceType, region)
+}
+
+// InstanceReady records the duration until the instance
+// accepts connections.
+func (m *Prometheus) InstanceReady(instanceType, region string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ready.observe(d.Seconds(), instanceType, region)
+}
+
+// InstanceTerminated records an instance termination, and
+// the estimated cost of the instance.
+func (m *Prometheus) InstanceTerminated(instanceType, region string, uptime time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.terminated.add(1, instanceType, region)
+	m.running.add(-1, instanceType, region)
+	if price, ok := m.prices[instanceType]; ok {
+		m.cost.add(price*uptime.Hours(), instanceType, region)
+	}
+}
+
+// TerminateFailed records a failed instance termination. The
+// instance is still running, and the running instances are not
+// updated.
+func (m *Prometheus) TerminateFailed(instanceType, region, code string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.termFailed.add(1, instanceType, region, code)
+}
+
+// StepCompleted records the step duration.
+func (m *Prometheus) StepCompleted(status string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.steps.observe(d.Seconds(), status)
+}
+
+// helper function returns the metrics in the prometheus text
+// exposition format.
+func (m *Prometheus) expose() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	buf := new(bytes.Buffer)
+	for _, v := range []*vector{
+		m.launched,
+		m.failed,
+		m.boot,
+		m.ready,
+		m.terminated,
+		m.termFailed,
+		m.running,
+		m.cost,
+		m.steps,
+	} {
+		v.write(buf)
+	}
+	return buf.Bytes()
+}
+
+// vector is a metric partitioned by label values.
+type vector struct {
+	kind   string
+	name   string
+	help   string
+	labels []string
+	series map[string]*series
+}
+
+// series is a single metric series. Counters and gauges only
+// use the sum.
+type series struct {
+	values  []string
+	sum     float64
+	count   uint64
+	buckets []uint64
+}
+
+func newVector(kind, name, help string, labels ...string) *vector {
+	return &vector{
+		kind:   kind,
+		name:   name,
+		help:   help,
+		labels: labels,
+		series: map[string]*series{},
+	}
+}
+
+func (v *vector) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{values: values}
+		if v.kind == "histogram" {
+			s.buckets = make([]uint64, len(durationBuckets))
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *vector) add(f float64, values ...string) {
+	v.get(values).sum += f
+}
+
+func (v *vector) observe(f float64, values ...string) {
+	s := v.get(values)
+	s.sum += f
+	s.count++
+	for i, le := range durationBuckets {
+		if f <= le {
+			s.buckets[i]++
+		}
+	}
+}
+
+func (v *vector) write(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", v.name, v.kind)
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := v.series[key]
+		labels := formatLabels(v.labels, s.values)
+		if v.kind != "histogram" {
+			fmt.Fprintf(buf, "%s%s %s\n", v.name, wrapLabels(labels), formatFloat(s.sum))
+			continue
+		}
+		for i, le := range durationBuckets {
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", v.name,
+				wrapLabels(append(labels, `le="`+formatFloat(le)+`"`)), s.buckets[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", v.name,
+			wrapLabels(append(labels, `le="+Inf"`)), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", v.name, wrapLabels(labels), formatFloat(s.sum))
+		fmt.Fprintf(buf, "%s_count%s %d\n", v.name, wrapLabels(labels), s.count)
+	}
+}
+
+// helper function returns the label pairs, with the label
+// values escaped.
+func formatLabels(names, values []string) []string {
+	out := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		out = append(out, name+"="+strconv.Quote(values[i]))
+	}
+	return out
+}
+
+func wrapLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -9,12 +9,17 @@ package platform
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"time"
 
+	"github.com/drone-runners/drone-runner-aws/internal/metrics"
+
 	"github.com/drone/runner-go/logger"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
@@ -25,6 +30,16 @@ type (
 		Client string
 		Secret string
 		Region string
+
+		// RoleARN is the optional role assumed using the
+		// credentials, with the optional external id.
+		RoleARN    string
+		ExternalID string
+
+		// Default allows the default credential chain of the
+		// runner, which includes the instance profile of the
+		// runner, when the client and secret are not provided.
+		Default bool
 	}
 
 	// ProvisionArgs provides arguments to provision instances.
@@ -48,14 +63,30 @@ type (
 
 	// Instance represents a provisioned server instance.
 	Instance struct {
-		ID string
-		IP string
+		ID        string
+		IP        string
+		Type      string
+		Region    string
+		Zone      string
+		Image     string
+		PrivateIP string
+		Market    string
+
+		// Launched is the time the instance was launched.
+		Launched time.Time
 	}
 )
 
-// Provision provisions the server instance.
-func create(ctx context.Context, creds Credentials, args ProvisionArgs) (*Instance, error) {
-	client := getClient(ctx, creds.Region, creds.Client, creds.Secret)
+// ErrNoCredentials is returned when the credentials are not
+// provided and the default credential chain is not allowed.
+var ErrNoCredentials = errors.New("platform: missing account credentials")
+
+// Create creates the server instance.
+func Create(ctx context.Context, creds Credentials, args ProvisionArgs) (*Instance, error) {
+	if !creds.allowed() {
+		return nil, ErrNoCredentials
+	}
+	client := getClient(ctx, creds)
 
 	var iamProfile *ec2.IamInstanceProfileSpecification
 
@@ -69,7 +100,6 @@ func create(ctx context.Context, creds Credentials, args ProvisionArgs) (*Instan
 	tags["Name"] = args.Name
 
 	in := &ec2.RunInstancesInput{
-		KeyName:            aws.String(args.Key),
 		ImageId:            aws.String(args.Image),
 		InstanceType:       aws.String(args.Size),
 		MinCount:           aws.Int64(1),
@@ -106,9 +136,20 @@ func create(ctx context.Context, creds Credentials, args ProvisionArgs) (*Instan
 		},
 	}
 
-	if args.VolumeType == "io1" {
-		for _, blockDeviceMapping := range in.BlockDeviceMappings {
-			blockDeviceMapping.Ebs.Iops = aws.Int64(args.VolumeIops)
+	// the key pair is optional. if not provided, the instance
+	// is accessed using the public key injected by userdata.
+	if args.Key != "" {
+		in.KeyName = aws.String(args.Key)
+	}
+
+	// provisioned iops are required for io1 and io2 disks,
+	// and optional for gp3 disks.
+	switch args.VolumeType {
+	case "io1", "io2", "gp3":
+		if args.VolumeIops != 0 {
+			for _, blockDeviceMapping := range in.BlockDeviceMappings {
+				blockDeviceMapping.Ebs.Iops = aws.Int64(args.VolumeIops)
+			}
 		}
 	}
 
@@ -120,18 +161,26 @@ func create(ctx context.Context, creds Credentials, args ProvisionArgs) (*Instan
 
 	logger.Debug("instance create")
 
+	metrics := metrics.FromContext(ctx)
+	launched := time.Now()
+
 	results, err := client.RunInstances(in)
 	if err != nil {
 		logger.WithError(err).
 			Error("instance create failed")
+		metrics.LaunchFailed(args.Size, args.Region, errorCode(err))
 		return nil, err
 	}
 
 	amazonInstance := results.Instances[0]
 
 	instance := &Instance{
-		ID: *amazonInstance.InstanceId,
+		ID:       *amazonInstance.InstanceId,
+		Region:   args.Region,
+		Launched: launched,
 	}
+	updateInstance(instance, amazonInstance)
+	metrics.InstanceLaunched(instance.Type, instance.Region)
 
 	logger.WithField("id", instance.ID).
 		Infoln("instance create success")
@@ -176,6 +225,7 @@ poller:
 			}
 
 			amazonInstance = desc.Reservations[0].Instances[0]
+			updateInstance(instance, amazonInstance)
 
 			if args.PrivateIP {
 				if amazonInstance.PrivateIpAddress != nil {
@@ -196,12 +246,37 @@ poller:
 		WithField("ip", instance.IP).
 		Debugln("instance network ready")
 
+	metrics.InstanceBooted(instance.Type, instance.Region, time.Since(launched))
+
 	return instance, nil
 }
 
+// ImageTags returns the tags of the image. The default
+// credential chain of the runner is used if the credentials
+// are not provided.
+func ImageTags(ctx context.Context, creds Credentials, image string) (map[string]string, error) {
+	client := getClient(ctx, creds)
+	out, err := client.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []*string{aws.String(image)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]string{}
+	for _, image := range out.Images {
+		for _, tag := range image.Tags {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	return tags, nil
+}
+
 // Destroy destroys the server instance.
 func Destroy(ctx context.Context, creds Credentials, instance *Instance) error {
-	client := getClient(ctx, creds.Region, creds.Client, creds.Secret)
+	if !creds.allowed() {
+		return ErrNoCredentials
+	}
+	client := getClient(ctx, creds)
 
 	logger := logger.FromContext(ctx).
 		WithField("id", instance.ID).
@@ -218,19 +293,58 @@ func Destroy(ctx context.Context, creds Credentials, instance *Instance) error {
 	if err != nil {
 		logger.WithError(err).
 			Errorln("cannot terminate instance")
+		metrics.FromContext(ctx).TerminateFailed(instance.Type, instance.Region, errorCode(err))
 		return err
 	}
 
 	logger.Debugln("terminated")
+
+	var uptime time.Duration
+	if !instance.Launched.IsZero() {
+		uptime = time.Since(instance.Launched)
+	}
+	metrics.FromContext(ctx).InstanceTerminated(instance.Type, instance.Region, uptime)
 	return nil
 }
 
-func getClient(ctx context.Context, region, client, secret string) *ec2.EC2 {
+// helper function returns the amazon error code, used to
+// partition the failed launch and termination metrics.
+func errorCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return "Unknown"
+}
+
+// helper function returns true if the credentials are
+// provided, or the default credential chain is allowed.
+func (c Credentials) allowed() bool {
+	return c.Client != "" || c.Default
+}
+
+func getClient(ctx context.Context, creds Credentials) *ec2.EC2 {
 	config := aws.NewConfig()
-	config = config.WithRegion(region)
+	config = config.WithRegion(creds.Region)
 	config = config.WithMaxRetries(10)
-	config = config.WithCredentials(
-		credentials.NewStaticCredentials(client, secret, ""),
-	)
-	return ec2.New(session.New(config))
+	// if the credentials are not provided the default
+	// credential chain is used, which includes the instance
+	// profile of the runner.
+	if creds.Client != "" {
+		config = config.WithCredentials(
+			credentials.NewStaticCredentials(creds.Client, creds.Secret, ""),
+		)
+	}
+	sess := session.New(config)
+	// if a role is provided, the role is assumed using the
+	// above credentials.
+	if creds.RoleARN != "" {
+		return ec2.New(sess, aws.NewConfig().WithCredentials(
+			stscreds.NewCredentials(sess, creds.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+				if creds.ExternalID != "" {
+					p.ExternalID = aws.String(creds.ExternalID)
+				}
+			}),
+		))
+	}
+	return ec2.New(sess)
 }
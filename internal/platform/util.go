@@ -57,3 +57,20 @@ var images = map[string]string{
 	"us-east-2":      "ami-916f59f4",
 	"eu-west-3":      "ami-0e55e373",
 }
+
+// helper function updates the instance with the details
+// reported by amazon.
+func updateInstance(instance *Instance, in *ec2.Instance) {
+	instance.Type = aws.StringValue(in.InstanceType)
+	instance.Image = aws.StringValue(in.ImageId)
+	instance.PrivateIP = aws.StringValue(in.PrivateIpAddress)
+	if in.Placement != nil {
+		instance.Zone = aws.StringValue(in.Placement.AvailabilityZone)
+	}
+	// the instance lifecycle is only set for spot and
+	// scheduled instances.
+	instance.Market = aws.StringValue(in.InstanceLifecycle)
+	if instance.Market == "" {
+		instance.Market = "on-demand"
+	}
+}
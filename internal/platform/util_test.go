@@ -8,6 +8,8 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/kr/pretty"
 )
 
@@ -30,3 +32,35 @@ func TestConvertTags(t *testing.T) {
 		pretty.Ldiff(t, a, b)
 	}
 }
+
+func TestUpdateInstance(t *testing.T) {
+	instance := &Instance{ID: "i-1234", Region: "us-east-1"}
+	updateInstance(instance, &ec2.Instance{
+		InstanceType:     aws.String("t3.nano"),
+		ImageId:          aws.String("ami-12345"),
+		PrivateIpAddress: aws.String("10.0.0.1"),
+		Placement: &ec2.Placement{
+			AvailabilityZone: aws.String("us-east-1a"),
+		},
+	})
+	want := &Instance{
+		ID:        "i-1234",
+		Region:    "us-east-1",
+		Type:      "t3.nano",
+		Zone:      "us-east-1a",
+		Image:     "ami-12345",
+		PrivateIP: "10.0.0.1",
+		Market:    "on-demand",
+	}
+	if !reflect.DeepEqual(instance, want) {
+		t.Errorf("unexpected instance details")
+		pretty.Ldiff(t, instance, want)
+	}
+
+	updateInstance(instance, &ec2.Instance{
+		InstanceLifecycle: aws.String("spot"),
+	})
+	if got, want := instance.Market, "spot"; got != want {
+		t.Errorf("Want market type %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package pool provides named instance pools, configured by
+// the runner, that pipelines reference by name instead of
+// defining the account and instance settings.
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/drone-runners/drone-runner-aws/engine/resource"
+
+	"github.com/buildkite/yaml"
+	"github.com/drone/runner-go/manifest"
+)
+
+// Pool defines a named instance pool.
+type Pool struct {
+	// Name is the pool name referenced by the pipeline.
+	Name string `yaml:"name"`
+
+	// Account provides the account credentials and region
+	// used to provision instances.
+	Account Account `yaml:"account"`
+
+	// Instance provides the instance settings.
+	Instance resource.Instance `yaml:"instance"`
+}
+
+// Account provides the pool account settings.
+type Account struct {
+	// Name references a named account configured by the
+	// runner, instead of the account credentials.
+	Name string `yaml:"name"`
+
+	AccessKeyID     string `yaml:"access_key_id"`
+	AccessKeySecret string `yaml:"secret_access_key"`
+	Region          string `yaml:"region"`
+}
+
+// Pools provides the named instance pools, indexed by name.
+type Pools map[string]*Pool
+
+// Lookup returns the named pool.
+func (p Pools) Lookup(name string) (*Pool, bool) {
+	pool, ok := p[name]
+	return pool, ok
+}
+
+// Load loads the pools from the yaml file.
+func Load(path string) (Pools, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(raw)
+}
+
+// Parse parses the pools from the yaml document.
+func Parse(data []byte) (Pools, error) {
+	var file struct {
+		Pools []*Pool `yaml:"pools"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	return New(file.Pools)
+}
+
+// New returns the named pools, indexed by name, and returns
+// an error if a pool is invalid.
+func New(list []*Pool) (Pools, error) {
+	pools := Pools{}
+	for _, pool := range list {
+		switch {
+		case pool == nil || pool.Name == "":
+			return nil, errors.New("pool: missing pool name")
+		case pools[pool.Name] != nil:
+			return nil, fmt.Errorf("pool: duplicate pool %s", pool.Name)
+		case pool.Instance.AMI == "":
+			return nil, fmt.Errorf("pool: pool %s requires an ami", pool.Name)
+		case pool.Account.Name != "" && (pool.Account.AccessKeyID != "" || pool.Account.AccessKeySecret != ""):
+			return nil, fmt.Errorf("pool: pool %s cannot combine a named account with access keys", pool.Name)
+		}
+		pools[pool.Name] = pool
+	}
+	return pools, nil
+}
+
+// Apply returns a copy of the pipeline with the account and
+// instance settings of the pool. The pipeline can override
+// the instance type, market type and disk size of the pool.
+func (p *Pool) Apply(pipeline *resource.Pipeline) *resource.Pipeline {
+	out := *pipeline
+	out.Account = resource.Account{
+		AccessKeyID:     manifest.Variable{Value: p.Account.AccessKeyID},
+		AccessKeySecret: manifest.Variable{Value: p.Account.AccessKeySecret},
+		Region:          p.Account.Region,
+		Name:            p.Account.Name,
+	}
+	out.Instance = p.Instance
+	if s := pipeline.Instance.Type; s != "" {
+		out.Instance.Type = s
+	}
+	if s := pipeline.Instance.Market; s != "" {
+		out.Instance.Market = s
+	}
+	if n := pipeline.Instance.Disk.Size; n != 0 {
+		out.Instance.Disk.Size = n
+	}
+	return &out
+}
+
+// Overrides returns the account and instance settings defined
+// by the pipeline that cannot override the pool settings.
+func Overrides(pipeline *resource.Pipeline) []string {
+	var out []string
+	if !isZero(pipeline.Account) {
+		out = append(out, "account")
+	}
+	instance := pipeline.Instance
+	fields := []struct {
+		name  string
+		value interface{}
+	}{
+		{"instance.ami", instance.AMI},
+		{"instance.user", instance.User},
+		{"instance.disk.type", instance.Disk.Type},
+		{"instance.disk.iops", instance.Disk.Iops},
+		{"instance.network", instance.Network},
+		{"instance.device", instance.Device},
+		{"instance.transport", instance.Transport},
+		{"instance.userdata", instance.Userdata},
+		{"instance.iam_profile_arn", instance.IAMProfileARN},
+		{"instance.docker", instance.Docker},
+	}
+	for _, field := range fields {
+		if !isZero(field.value) {
+			out = append(out, field.name)
+		}
+	}
+	return out
+}
+
+// helper function returns true if the value is the zero
+// value of its type.
+func isZero(v interface{}) bool {
+	return reflect.DeepEqual(v, reflect.Zero(reflect.TypeOf(v)).Interface())
+}
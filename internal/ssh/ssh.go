@@ -6,7 +6,7 @@ package ssh
 
 import (
 	"context"
-	"strings"
+	"net"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -61,8 +61,8 @@ func DialRetry(ctx context.Context, ip, username, privatekey string) (*ssh.Clien
 
 // Dial configures and dials the ssh server.
 func Dial(server, username, privatekey string) (*ssh.Client, error) {
-	if !strings.HasSuffix(server, ":22") {
-		server = server + ":22"
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "22")
 	}
 	config := &ssh.ClientConfig{
 		User:            username,
@@ -0,0 +1,171 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package userdata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// MaxSize is the maximum size of the userdata, in bytes,
+// accepted by the EC2 api.
+const MaxSize = 16 * 1024
+
+// boundary used to separate the multipart userdata sections.
+const boundary = "==DRONE_USERDATA_BOUNDARY=="
+
+// merge type instructs cloud-init to merge the user-supplied
+// cloud-config without replacing the generated configuration.
+const mergeType = "list(append)+dict(no_replace,recurse_list)+str()"
+
+// ErrFormat is returned when the user-supplied userdata is
+// neither a cloud-config document nor a shell script.
+var ErrFormat = errors.New("userdata: must be a #cloud-config document or a #! script")
+
+// ErrPowershell is returned when the user-supplied userdata
+// for the windows operating system is not a powershell script.
+var ErrPowershell = errors.New("userdata: must be a <powershell> script on windows")
+
+// ErrSize is returned when the merged userdata exceeds the
+// maximum size.
+var ErrSize = errors.New("userdata: exceeds the 16KB limit")
+
+// Check returns an error if the user-supplied userdata cannot
+// be merged with the userdata generated for the operating
+// system and transport, or if the merged userdata exceeds the
+// maximum size. The generated userdata is rendered with
+// placeholder credentials matching the length of the generated
+// credentials.
+func Check(os, transport, user, custom string) error {
+	params := Params{
+		PublicKey: strings.Repeat("A", 372),
+		User:      user,
+		Password:  strings.Repeat("A", 30),
+	}
+	var generated string
+	switch {
+	case os == "windows" && transport == "winrm":
+		generated = WinRM(params)
+	case os == "windows":
+		generated = Windows(params)
+	default:
+		generated = Linux(params)
+	}
+	merged, err := Merge(os, generated, custom)
+	if err != nil {
+		return err
+	}
+	if len(merged) > MaxSize {
+		return ErrSize
+	}
+	return nil
+}
+
+// Validate returns an error if the default userdata provided
+// by the runner cannot be merged with the generated userdata,
+// or if the merged userdata exceeds the maximum size. Powershell
+// scripts are validated against the windows userdata, and all
+// other userdata against the linux userdata.
+func Validate(custom string) error {
+	if strings.HasPrefix(strings.TrimSpace(custom), "<powershell>") {
+		if err := Check("windows", "ssh", "Administrator", custom); err != nil {
+			return err
+		}
+		return Check("windows", "winrm", "Administrator", custom)
+	}
+	return Check("linux", "ssh", "root", custom)
+}
+
+// Merge combines the generated userdata with the user-supplied
+// userdata. For the windows operating system the user-supplied
+// powershell script is appended to the generated script, and
+// other userdata formats are rejected. For
+// all other operating systems the userdata is combined into a
+// multipart mime document processed by cloud-init.
+func Merge(os, generated, custom string) (string, error) {
+	if strings.TrimSpace(custom) == "" {
+		return generated, nil
+	}
+	switch os {
+	case "windows":
+		if !strings.HasPrefix(strings.TrimSpace(custom), "<powershell>") {
+			return "", ErrPowershell
+		}
+		return mergePowershell(generated, custom), nil
+	default:
+		return mergeMultipart(generated, custom)
+	}
+}
+
+// helper function appends the user-supplied powershell script
+// to the generated powershell script.
+func mergePowershell(generated, custom string) string {
+	custom = strings.TrimSpace(custom)
+	custom = strings.TrimPrefix(custom, "<powershell>")
+	custom = strings.TrimSuffix(custom, "</powershell>")
+	custom = strings.TrimSpace(custom)
+
+	generated = strings.TrimSpace(generated)
+	generated = strings.TrimSuffix(generated, "</powershell>")
+	return fmt.Sprintf("%s\n# user-supplied userdata.\n%s\n</powershell>\n", generated, custom)
+}
+
+// helper function combines the generated cloud-config and the
+// user-supplied userdata into a multipart mime document.
+func mergeMultipart(generated, custom string) (string, error) {
+	contentType, err := detect(custom)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(custom, boundary) {
+		return "", errors.New("userdata: contains the reserved multipart boundary")
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "Content-Type: multipart/mixed; boundary=%q\r\n", boundary)
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n\r\n")
+
+	w := multipart.NewWriter(buf)
+	w.SetBoundary(boundary)
+
+	part, _ := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {`text/cloud-config; charset="us-ascii"`},
+		"Mime-Version":        {"1.0"},
+		"Content-Disposition": {`attachment; filename="drone.cfg"`},
+	})
+	part.Write([]byte(generated))
+
+	header := textproto.MIMEHeader{
+		"Content-Type":        {contentType + `; charset="us-ascii"`},
+		"Mime-Version":        {"1.0"},
+		"Content-Disposition": {`attachment; filename="userdata"`},
+	}
+	if contentType == "text/cloud-config" {
+		header.Set("X-Merge-Type", mergeType)
+	}
+	part, _ = w.CreatePart(header)
+	part.Write([]byte(custom))
+
+	w.Close()
+	return buf.String(), nil
+}
+
+// helper function returns the mime content type of the
+// user-supplied userdata based on its header line.
+func detect(custom string) (string, error) {
+	custom = strings.TrimSpace(custom)
+	switch {
+	case strings.HasPrefix(custom, "#cloud-config"):
+		return "text/cloud-config", nil
+	case strings.HasPrefix(custom, "#!"):
+		return "text/x-shellscript", nil
+	default:
+		return "", ErrFormat
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package userdata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMerge_CloudConfig(t *testing.T) {
+	custom := "#cloud-config\npackages:\n- git\n- make\n"
+	got, err := Merge("linux", Linux(testParams), custom)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testUserdata(t, got, "testdata/merge_cloudconfig.golden")
+}
+
+func TestMerge_Script(t *testing.T) {
+	custom := "#!/bin/sh\nmkfs -t ext4 /dev/xvdb\nmount /dev/xvdb /mnt\n"
+	got, err := Merge("linux", Linux(testParams), custom)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testUserdata(t, got, "testdata/merge_script.golden")
+}
+
+func TestMerge_Windows(t *testing.T) {
+	custom := "<powershell>\nInstall-WindowsFeature -Name Web-Server\n</powershell>\n"
+	got, err := Merge("windows", Windows(testParams), custom)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testUserdata(t, got, "testdata/merge_windows.golden")
+}
+
+// This test verifies the generated userdata is returned
+// unchanged when no custom userdata is provided.
+func TestMerge_Empty(t *testing.T) {
+	generated := Linux(testParams)
+	got, err := Merge("linux", generated, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got != generated {
+		t.Errorf("Want generated userdata unchanged")
+	}
+}
+
+func TestMerge_InvalidFormat(t *testing.T) {
+	_, err := Merge("linux", Linux(testParams), "apt-get install git")
+	if err != ErrFormat {
+		t.Errorf("Want format error, got %v", err)
+	}
+}
+
+// This test verifies cloud-config documents and scripts are
+// rejected on windows, where they cannot be merged with the
+// generated powershell script.
+func TestMerge_WindowsFormat(t *testing.T) {
+	for _, custom := range []string{
+		"#cloud-config\npackages:\n- git\n",
+		"#!/bin/sh\nmount /dev/xvdb /mnt\n",
+	} {
+		if _, err := Merge("windows", Windows(testParams), custom); err != ErrPowershell {
+			t.Errorf("Want powershell error, got %v", err)
+		}
+		if err := Check("windows", "ssh", "Administrator", custom); err != ErrPowershell {
+			t.Errorf("Want powershell error from check, got %v", err)
+		}
+	}
+}
+
+func TestMerge_Boundary(t *testing.T) {
+	_, err := Merge("linux", Linux(testParams), "#!/bin/sh\necho "+boundary)
+	if err == nil {
+		t.Errorf("Want error when custom userdata contains the boundary")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		custom string
+		err    error
+	}{
+		{custom: "#cloud-config\npackages:\n- git\n", err: nil},
+		{custom: "#!/bin/sh\nmount /dev/xvdb /mnt\n", err: nil},
+		{custom: "<powershell>\nInstall-WindowsFeature -Name Web-Server\n</powershell>\n", err: nil},
+		{custom: "apt-get install git", err: ErrFormat},
+		{custom: "#!/bin/sh\n" + strings.Repeat("#", MaxSize), err: ErrSize},
+		{custom: "<powershell>\n" + strings.Repeat("#", MaxSize), err: ErrSize},
+	}
+	for _, test := range tests {
+		if got, want := Validate(test.custom), test.err; got != want {
+			t.Errorf("Want error %v, got %v", want, got)
+		}
+	}
+}
@@ -6,11 +6,16 @@
 // executed by the cloud-init directive.
 package userdata
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Params defines parameters used to create userdata files.
 type Params struct {
 	PublicKey string
+	User      string
+	Password  string
 }
 
 // Linux creates a userdata file for the Linux operating system.
@@ -22,11 +27,75 @@ users:
 - name: root
   sudo: ALL=(ALL) NOPASSWD:ALL
   ssh-authorized-keys:
-  - ssh-rsa %s drone@localhost	
+  - ssh-rsa %s drone@localhost
 `, params.PublicKey)
 }
 
-// Windows creates a userdata file for the Windows operating system.
-func Windows() string {
-	return ""
+// Windows creates a userdata file for the Windows operating
+// system. The userdata installs and configures the OpenSSH
+// server and authorizes the public key for the named user.
+func Windows(params Params) string {
+	return fmt.Sprintf(`<powershell>
+$ErrorActionPreference = "Stop"
+
+# install the openssh server.
+Add-WindowsCapability -Online -Name OpenSSH.Server~~~~0.0.1.0
+Set-Service -Name sshd -StartupType Automatic
+
+# authorize the public key. members of the administrators
+# group read authorized keys from a shared location.
+$user = %s
+$key = "ssh-rsa %s drone@localhost"
+$admin = Get-LocalGroupMember -Group Administrators -ErrorAction SilentlyContinue | Where-Object { $_.Name -like "*\$user" }
+if ($admin) {
+	$path = "C:\ProgramData\ssh\administrators_authorized_keys"
+	New-Item -ItemType Directory -Force -Path "C:\ProgramData\ssh" | Out-Null
+	Set-Content -Path $path -Value $key -Encoding ascii
+	icacls.exe $path /inheritance:r /grant "Administrators:F" /grant "SYSTEM:F"
+} else {
+	$dir = "C:\Users\$user\.ssh"
+	New-Item -ItemType Directory -Force -Path $dir | Out-Null
+	Set-Content -Path "$dir\authorized_keys" -Value $key -Encoding ascii
+}
+
+# configure powershell as the default shell.
+New-ItemProperty -Path "HKLM:\SOFTWARE\OpenSSH" -Name DefaultShell -Value "C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe" -PropertyType String -Force
+
+# open the firewall and start the openssh server.
+New-NetFirewallRule -Name sshd -DisplayName "OpenSSH Server (sshd)" -Enabled True -Direction Inbound -Protocol TCP -Action Allow -LocalPort 22
+Start-Service sshd
+</powershell>
+`, quote(params.User), params.PublicKey)
+}
+
+// WinRM creates a userdata file for the Windows operating
+// system. The userdata configures the password for the named
+// user and enables the winrm https listener.
+func WinRM(params Params) string {
+	return fmt.Sprintf(`<powershell>
+$ErrorActionPreference = "Stop"
+
+# configure the password used to authenticate the user.
+net user %s %s
+
+# enable winrm with basic authentication over https, using
+# a self-signed certificate.
+Enable-PSRemoting -Force -SkipNetworkProfileCheck
+$cert = New-SelfSignedCertificate -DnsName $env:COMPUTERNAME -CertStoreLocation Cert:\LocalMachine\My
+Get-ChildItem -Path WSMan:\localhost\Listener | Where-Object { $_.Keys -contains "Transport=HTTPS" } | Remove-Item -Recurse -Force
+New-Item -Path WSMan:\localhost\Listener -Transport HTTPS -Address * -CertificateThumbPrint $cert.Thumbprint -Force
+Set-Item -Path WSMan:\localhost\Service\Auth\Basic -Value $true
+
+# open the firewall and restart the winrm service.
+New-NetFirewallRule -Name winrm-https -DisplayName "WinRM (HTTPS)" -Enabled True -Direction Inbound -Protocol TCP -Action Allow -LocalPort 5986
+Restart-Service winrm
+</powershell>
+`, quote(params.User), quote(params.Password))
+}
+
+// helper function returns the string as a single-quoted
+// powershell literal, which does not expand variables or
+// subexpressions.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
 }
@@ -0,0 +1,76 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package userdata
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var testParams = Params{
+	PublicKey: "AAAAB3NzaC1yc2EAAAADAQABAAABAQC7",
+	User:      "Administrator",
+	Password:  "correct-horse-battery-staple",
+}
+
+func TestLinux(t *testing.T) {
+	testUserdata(t, Linux(testParams), "testdata/linux.golden")
+}
+
+func TestWindows(t *testing.T) {
+	testUserdata(t, Windows(testParams), "testdata/windows.golden")
+}
+
+// This test verifies the public key is authorized for
+// non-administrator users in the user profile directory.
+func TestWindows_User(t *testing.T) {
+	params := testParams
+	params.User = "drone"
+	testUserdata(t, Windows(params), "testdata/windows_user.golden")
+}
+
+// This test verifies the user name is written as a literal,
+// and cannot expand powershell variables or subexpressions.
+func TestWindows_Quote(t *testing.T) {
+	params := testParams
+	params.User = "o'neil $(whoami)"
+	got := Windows(params)
+	if want := "$user = 'o''neil $(whoami)'\n"; !strings.Contains(got, want) {
+		t.Errorf("Want quoted user %q", want)
+	}
+}
+
+func TestWinRM(t *testing.T) {
+	testUserdata(t, WinRM(testParams), "testdata/winrm.golden")
+}
+
+// This test verifies the user name and password are written
+// as literals, and cannot expand powershell variables or
+// subexpressions.
+func TestWinRM_Quote(t *testing.T) {
+	params := testParams
+	params.User = "o'neil $(whoami)"
+	params.Password = "pa$s'word"
+	got := WinRM(params)
+	if want := "net user 'o''neil $(whoami)' 'pa$s''word'\n"; !strings.Contains(got, want) {
+		t.Errorf("Want quoted user and password %q", want)
+	}
+}
+
+// helper function compares the rendered userdata to a
+// golden file.
+func testUserdata(t *testing.T, got, golden string) {
+	raw, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if diff := cmp.Diff(got, string(raw)); diff != "" {
+		t.Errorf(diff)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package winrm
+
+const (
+	actionCreate  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	actionDelete  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	actionCommand = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command"
+	actionReceive = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive"
+	actionSignal  = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal"
+
+	stateDone   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"
+	codeTimeout = "2150858793"
+)
+
+const requestEnvelope = `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">
+<env:Header>
+<a:To>%s</a:To>
+<a:ReplyTo><a:Address env:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>
+<a:MessageID>uuid:%s</a:MessageID>
+<a:Action env:mustUnderstand="true">%s</a:Action>
+<w:ResourceURI env:mustUnderstand="true">http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd</w:ResourceURI>
+<w:MaxEnvelopeSize env:mustUnderstand="true">153600</w:MaxEnvelopeSize>
+<w:OperationTimeout>PT60S</w:OperationTimeout>
+<w:Locale env:mustUnderstand="false" xml:lang="en-US"/>
+%s
+</env:Header>
+<env:Body>%s</env:Body>
+</env:Envelope>`
+
+const selectorHeader = `<w:SelectorSet><w:Selector Name="ShellId">%s</w:Selector></w:SelectorSet>`
+
+const shellBody = `<rsp:Shell><rsp:InputStreams>stdin</rsp:InputStreams><rsp:OutputStreams>stdout stderr</rsp:OutputStreams></rsp:Shell>`
+
+const commandBody = `<rsp:CommandLine><rsp:Command>%s</rsp:Command></rsp:CommandLine>`
+
+const receiveBody = `<rsp:Receive><rsp:DesiredStream CommandId="%s">stdout stderr</rsp:DesiredStream></rsp:Receive>`
+
+const signalBody = `<rsp:Signal CommandId="%s"><rsp:Code>http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate</rsp:Code></rsp:Signal>`
+
+const uploadScript = `$ProgressPreference = 'SilentlyContinue'
+$data = [System.Convert]::FromBase64String('%s')
+$file = [System.IO.File]::Open(%s, [System.IO.FileMode]::%s)
+$file.Write($data, 0, $data.Length)
+$file.Close()`
+
+const mkdirScript = `New-Item -ItemType Directory -Force -Path %s | Out-Null`
+
+const readScript = `$ErrorActionPreference = 'Stop'
+$file = [IO.File]::Open(%s, 'Open', 'Read', 'ReadWrite')
+$file.Seek(%d, 'Begin') | Out-Null
+$data = New-Object byte[] ([Math]::Max(0, $file.Length - $file.Position))
+$size = $file.Read($data, 0, $data.Length)
+$file.Close()
+[Convert]::ToBase64String($data, 0, $size)`
+
+const readRangeScript = `$ErrorActionPreference = 'Stop'
+$file = [IO.File]::Open(%s, 'Open', 'Read', 'ReadWrite')
+$file.Seek(%d, 'Begin') | Out-Null
+$data = New-Object byte[] ([Math]::Min(%d, [Math]::Max(0, $file.Length - $file.Position)))
+$size = $file.Read($data, 0, $data.Length)
+$file.Close()
+[Convert]::ToBase64String($data, 0, $size)`
+
+const pingScript = `$ErrorActionPreference = 'Stop'
+$client = New-Object Net.Sockets.TcpClient
+$client.Connect('127.0.0.1', %d)
+$client.Close()`
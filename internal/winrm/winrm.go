@@ -0,0 +1,477 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package winrm provides a minimal WinRM client used to upload
+// files and execute commands on windows instances.
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/drone/runner-go/logger"
+)
+
+const networkTimeout = time.Minute * 10
+
+// chunk size used to upload files. the size is chosen so that
+// the encoded upload command does not exceed the maximum
+// windows command line length.
+const chunkSize = 1500
+
+// readChunkSize is the maximum number of bytes read from a
+// remote file by a single command when the file is copied to
+// a writer.
+const readChunkSize = 1 << 20
+
+// ErrUnauthorized is returned when the server rejects the
+// client credentials.
+var ErrUnauthorized = errors.New("winrm: unauthorized")
+
+// Client provides a WinRM client.
+type Client struct {
+	endpoint string
+	username string
+	password string
+	client   *http.Client
+}
+
+// DialRetry configures and dials the winrm server and
+// retries until a connection is established or a timeout
+// is reached.
+func DialRetry(ctx context.Context, ip, username, password string) (*Client, error) {
+	client, err := Dial(ip, username, password)
+	if err == nil {
+		return client, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, networkTimeout)
+	defer cancel()
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		logger.FromContext(ctx).
+			WithField("ip", ip).
+			WithField("attempt", i).
+			Trace("dialing the vm")
+		client, err = Dial(ip, username, password)
+		if err == nil {
+			return client, nil
+		}
+		logger.FromContext(ctx).
+			WithError(err).
+			WithField("ip", ip).
+			WithField("attempt", i).
+			Trace("failed to re-dial vm")
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second * 10):
+		}
+	}
+}
+
+// Dial configures the winrm client and verifies the server
+// is reachable by opening and closing a remote shell.
+func Dial(server, username, password string) (*Client, error) {
+	client := New(server, username, password)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	shell, err := client.createShell(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client.deleteShell(ctx, shell)
+	return client, nil
+}
+
+// New returns a new winrm client for the server. The client
+// connects over https and does not verify the server
+// certificate, which is self-signed by the instance.
+func New(server, username, password string) *Client {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "5986")
+	}
+	return &Client{
+		endpoint: "https://" + server + "/wsman",
+		username: username,
+		password: password,
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+				},
+			},
+		},
+	}
+}
+
+// Run executes the command in a remote shell and writes the
+// command output to the writers. It returns the command exit
+// code. If the context is cancelled the remote command is
+// terminated.
+func (c *Client) Run(ctx context.Context, command string, stdout, stderr io.Writer) (int, error) {
+	shell, err := c.createShell(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer c.deleteShell(context.Background(), shell)
+	return c.run(ctx, shell, command, stdout, stderr)
+}
+
+// Upload writes the data to the named file on the remote
+// server. The data is written in chunks, each of which is
+// decoded and appended to the file by a powershell command.
+func (c *Client) Upload(ctx context.Context, path string, data []byte) error {
+	return c.UploadFrom(ctx, path, bytes.NewReader(data))
+}
+
+// UploadFrom writes the contents of the reader to the named
+// file on the remote server. The contents are read and written
+// in chunks, and are never fully buffered in memory.
+func (c *Client) UploadFrom(ctx context.Context, path string, r io.Reader) error {
+	shell, err := c.createShell(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.deleteShell(context.Background(), shell)
+
+	mode := "Create"
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		switch {
+		case err == io.EOF && mode == "Append":
+			return nil
+		case err != nil && err != io.EOF && err != io.ErrUnexpectedEOF:
+			return err
+		}
+		script := fmt.Sprintf(uploadScript,
+			base64.StdEncoding.EncodeToString(buf[:n]),
+			quote(path),
+			mode,
+		)
+		mode = "Append"
+		if err := c.powershell(ctx, shell, script); err != nil {
+			return err
+		}
+		if n < chunkSize {
+			return nil
+		}
+	}
+}
+
+// Mkdir creates the named directory, along with any necessary
+// parents, on the remote server.
+func (c *Client) Mkdir(ctx context.Context, path string) error {
+	shell, err := c.createShell(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.deleteShell(context.Background(), shell)
+	return c.powershell(ctx, shell, fmt.Sprintf(mkdirScript, quote(path)))
+}
+
+// ReadFile reads the named file on the remote server, starting
+// at the offset, and returns the contents.
+func (c *Client) ReadFile(ctx context.Context, path string, offset int64) ([]byte, error) {
+	shell, err := c.createShell(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.deleteShell(context.Background(), shell)
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	script := fmt.Sprintf(readScript, quote(path), offset)
+	code, err := c.run(ctx, shell, encodeCommand(script), stdout, stderr)
+	if err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("winrm: command failed with exit code %d: %s",
+			code, strings.TrimSpace(stderr.String()))
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(stdout.String()))
+}
+
+// DownloadTo copies the named file on the remote server to
+// the writer. The file is read in chunks, and is never fully
+// buffered in memory.
+func (c *Client) DownloadTo(ctx context.Context, path string, w io.Writer) error {
+	shell, err := c.createShell(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.deleteShell(context.Background(), shell)
+
+	for offset := int64(0); ; {
+		stdout := new(bytes.Buffer)
+		stderr := new(bytes.Buffer)
+		script := fmt.Sprintf(readRangeScript, quote(path), offset, readChunkSize)
+		code, err := c.run(ctx, shell, encodeCommand(script), stdout, stderr)
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return fmt.Errorf("winrm: command failed with exit code %d: %s",
+				code, strings.TrimSpace(stderr.String()))
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stdout.String()))
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		if len(data) < readChunkSize {
+			return nil
+		}
+		offset += int64(len(data))
+	}
+}
+
+// Ping returns an error if the local port on the remote
+// server does not accept connections.
+func (c *Client) Ping(ctx context.Context, port int) error {
+	shell, err := c.createShell(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.deleteShell(context.Background(), shell)
+	return c.powershell(ctx, shell, fmt.Sprintf(pingScript, port))
+}
+
+// helper function executes the powershell script in the
+// remote shell and returns an error if the script fails.
+func (c *Client) powershell(ctx context.Context, shell, script string) error {
+	stderr := new(bytes.Buffer)
+	code, err := c.run(ctx, shell, encodeCommand(script), ioutil.Discard, stderr)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("winrm: command failed with exit code %d: %s",
+			code, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// helper function executes the command in the remote shell
+// and streams the output until the command completes.
+func (c *Client) run(ctx context.Context, shell, command string, stdout, stderr io.Writer) (int, error) {
+	res, err := c.send(ctx, shell, actionCommand,
+		fmt.Sprintf(commandBody, escape(command)),
+	)
+	if err != nil {
+		return 0, err
+	}
+	id := res.Body.CommandResponse.CommandID
+
+	for {
+		res, err := c.send(ctx, shell, actionReceive,
+			fmt.Sprintf(receiveBody, id),
+		)
+		if isTimeout(err) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				c.signal(context.Background(), shell, id)
+				return 0, ctx.Err()
+			}
+			return 0, err
+		}
+		for _, stream := range res.Body.ReceiveResponse.Streams {
+			data, err := base64.StdEncoding.DecodeString(stream.Data)
+			if err != nil {
+				return 0, err
+			}
+			switch stream.Name {
+			case "stdout":
+				stdout.Write(data)
+			case "stderr":
+				stderr.Write(data)
+			}
+		}
+		state := res.Body.ReceiveResponse.CommandState
+		if state.State == stateDone {
+			return state.ExitCode, nil
+		}
+	}
+}
+
+// helper function creates a remote shell and returns the
+// shell identifier.
+func (c *Client) createShell(ctx context.Context) (string, error) {
+	res, err := c.send(ctx, "", actionCreate, shellBody)
+	if err != nil {
+		return "", err
+	}
+	return res.Body.Shell.ShellID, nil
+}
+
+// helper function deletes the remote shell.
+func (c *Client) deleteShell(ctx context.Context, shell string) error {
+	_, err := c.send(ctx, shell, actionDelete, "")
+	return err
+}
+
+// helper function terminates the remote command.
+func (c *Client) signal(ctx context.Context, shell, command string) error {
+	_, err := c.send(ctx, shell, actionSignal,
+		fmt.Sprintf(signalBody, command),
+	)
+	return err
+}
+
+// helper function sends the soap request to the server and
+// decodes the soap response.
+func (c *Client) send(ctx context.Context, shell, action, body string) (*envelope, error) {
+	var selector string
+	if shell != "" {
+		selector = fmt.Sprintf(selectorHeader, shell)
+	}
+	payload := fmt.Sprintf(requestEnvelope,
+		escape(c.endpoint),
+		uuid(),
+		action,
+		selector,
+		body,
+	)
+
+	req, err := http.NewRequest("POST", c.endpoint, strings.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return nil, ErrUnauthorized
+	}
+
+	out := new(envelope)
+	if err := xml.NewDecoder(res.Body).Decode(out); err != nil {
+		return nil, fmt.Errorf("winrm: cannot decode response: %s", err)
+	}
+	if fault := out.Body.Fault; fault != nil {
+		return nil, fault
+	}
+	if res.StatusCode > 299 {
+		return nil, fmt.Errorf("winrm: unexpected status code %d", res.StatusCode)
+	}
+	return out, nil
+}
+
+type (
+	envelope struct {
+		Body struct {
+			Shell struct {
+				ShellID string `xml:"ShellId"`
+			} `xml:"Shell"`
+			CommandResponse struct {
+				CommandID string `xml:"CommandId"`
+			} `xml:"CommandResponse"`
+			ReceiveResponse struct {
+				Streams      []stream     `xml:"Stream"`
+				CommandState commandState `xml:"CommandState"`
+			} `xml:"ReceiveResponse"`
+			Fault *Fault `xml:"Fault"`
+		} `xml:"Body"`
+	}
+
+	stream struct {
+		Name string `xml:"Name,attr"`
+		Data string `xml:",chardata"`
+	}
+
+	commandState struct {
+		State    string `xml:"State,attr"`
+		ExitCode int    `xml:"ExitCode"`
+	}
+
+	// Fault represents a soap fault returned by the server.
+	Fault struct {
+		Reason string `xml:"Reason>Text"`
+		Detail struct {
+			Code    string `xml:"Code,attr"`
+			Message string `xml:"Message"`
+		} `xml:"Detail>WSManFault"`
+	}
+)
+
+// Error returns the fault message.
+func (f *Fault) Error() string {
+	if f.Detail.Message != "" {
+		return "winrm: " + strings.TrimSpace(f.Detail.Message)
+	}
+	return "winrm: " + strings.TrimSpace(f.Reason)
+}
+
+// helper function returns true if the error is an operation
+// timeout fault, which indicates the command is still running
+// and produced no output before the receive timeout elapsed.
+func isTimeout(err error) bool {
+	fault, ok := err.(*Fault)
+	return ok && fault.Detail.Code == codeTimeout
+}
+
+// helper function returns the command encoded as a powershell
+// encoded command.
+func encodeCommand(script string) string {
+	var buf bytes.Buffer
+	for _, r := range utf16.Encode([]rune(script)) {
+		buf.WriteByte(byte(r))
+		buf.WriteByte(byte(r >> 8))
+	}
+	return "powershell -NoProfile -NonInteractive -EncodedCommand " +
+		base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// helper function returns the string escaped for xml.
+func escape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// helper function returns the string in single quotes, escaped
+// for powershell.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// helper function returns a random message identifier.
+func uuid() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,323 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf16"
+)
+
+var nocontext = context.Background()
+
+func TestRun(t *testing.T) {
+	s := &stub{stdout: "hello world", exitCode: 3}
+	server := httptest.NewTLSServer(s)
+	defer server.Close()
+
+	client := New(server.Listener.Addr().String(), "Administrator", "password")
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	code, err := client.Run(nocontext, "powershell -command C:\\step.ps1", stdout, stderr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := code, 3; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+	if got, want := stdout.String(), "hello world"; got != want {
+		t.Errorf("Want stdout %q, got %q", want, got)
+	}
+	if got, want := s.commands, []string{"powershell -command C:\\step.ps1"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Want commands %q, got %q", want, got)
+	}
+	if s.shells != 0 {
+		t.Errorf("Want remote shells deleted, got %d open shells", s.shells)
+	}
+}
+
+// This test verifies the client continues to receive output
+// when the server responds with an operation timeout fault.
+func TestRun_OperationTimeout(t *testing.T) {
+	s := &stub{stdout: "hello world", timeouts: 2}
+	server := httptest.NewTLSServer(s)
+	defer server.Close()
+
+	client := New(server.Listener.Addr().String(), "Administrator", "password")
+
+	stdout := new(bytes.Buffer)
+	code, err := client.Run(nocontext, "whoami", stdout, stdout)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if code != 0 {
+		t.Errorf("Want exit code 0, got %d", code)
+	}
+	if got, want := stdout.String(), "hello world"; got != want {
+		t.Errorf("Want stdout %q, got %q", want, got)
+	}
+}
+
+func TestRun_Unauthorized(t *testing.T) {
+	server := httptest.NewTLSServer(new(stub))
+	defer server.Close()
+
+	client := New(server.Listener.Addr().String(), "Administrator", "invalid")
+	_, err := client.Run(nocontext, "whoami", new(bytes.Buffer), new(bytes.Buffer))
+	if err != ErrUnauthorized {
+		t.Errorf("Want unauthorized error, got %v", err)
+	}
+}
+
+// This test verifies that files are uploaded in chunks and
+// that the chunks are re-assembled in order.
+func TestUpload(t *testing.T) {
+	s := new(stub)
+	server := httptest.NewTLSServer(s)
+	defer server.Close()
+
+	data := bytes.Repeat([]byte("0123456789"), 400)
+
+	client := New(server.Listener.Addr().String(), "Administrator", "password")
+	err := client.Upload(nocontext, `C:\Windows\Temp\step.ps1`, data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if got, want := len(s.commands), 3; got != want {
+		t.Errorf("Want %d upload commands, got %d", want, got)
+	}
+
+	got := new(bytes.Buffer)
+	for i, command := range s.commands {
+		script := decodeCommand(t, command)
+		if !strings.Contains(script, `'C:\Windows\Temp\step.ps1'`) {
+			t.Errorf("Want script to write to the target path")
+		}
+		mode := "Append"
+		if i == 0 {
+			mode = "Create"
+		}
+		if !strings.Contains(script, "[System.IO.FileMode]::"+mode) {
+			t.Errorf("Want chunk %d written with file mode %s", i, mode)
+		}
+		match := regexp.MustCompile(`FromBase64String\('(.*)'\)`).FindStringSubmatch(script)
+		chunk, _ := base64.StdEncoding.DecodeString(match[1])
+		got.Write(chunk)
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("Want uploaded data to match the source data")
+	}
+}
+
+func TestMkdir(t *testing.T) {
+	s := new(stub)
+	server := httptest.NewTLSServer(s)
+	defer server.Close()
+
+	client := New(server.Listener.Addr().String(), "Administrator", "password")
+	err := client.Mkdir(nocontext, `C:\Windows\Temp\drone-random`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(s.commands) != 1 {
+		t.Errorf("Want a single command, got %d", len(s.commands))
+		return
+	}
+	got := decodeCommand(t, s.commands[0])
+	want := `New-Item -ItemType Directory -Force -Path 'C:\Windows\Temp\drone-random' | Out-Null`
+	if got != want {
+		t.Errorf("Want script %q, got %q", want, got)
+	}
+}
+
+func TestMkdir_Error(t *testing.T) {
+	s := &stub{exitCode: 1}
+	server := httptest.NewTLSServer(s)
+	defer server.Close()
+
+	client := New(server.Listener.Addr().String(), "Administrator", "password")
+	err := client.Mkdir(nocontext, `C:\Windows\Temp\drone-random`)
+	if err == nil {
+		t.Errorf("Want error when the remote command fails")
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	s := &stub{stdout: "aGVsbG8gd29ybGQ=\r\n"}
+	server := httptest.NewTLSServer(s)
+	defer server.Close()
+
+	client := New(server.Listener.Addr().String(), "Administrator", "password")
+	data, err := client.ReadFile(nocontext, `C:\Windows\Temp\drone.log`, 6)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := string(data), "hello world"; got != want {
+		t.Errorf("Want data %q, got %q", want, got)
+	}
+	got := decodeCommand(t, s.commands[0])
+	if !strings.Contains(got, "$file.Seek(6, 'Begin')") {
+		t.Errorf("Want script seeks to the offset, got %q", got)
+	}
+}
+
+func TestDownloadTo(t *testing.T) {
+	s := &stub{stdout: "aGVsbG8gd29ybGQ=\r\n"}
+	server := httptest.NewTLSServer(s)
+	defer server.Close()
+
+	client := New(server.Listener.Addr().String(), "Administrator", "password")
+	buf := new(bytes.Buffer)
+	err := client.DownloadTo(nocontext, `C:\Windows\Temp\cache.tar.gz`, buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := buf.String(), "hello world"; got != want {
+		t.Errorf("Want data %q, got %q", want, got)
+	}
+	if got, want := len(s.commands), 1; got != want {
+		t.Errorf("Want %d read commands, got %d", want, got)
+		return
+	}
+	got := decodeCommand(t, s.commands[0])
+	if !strings.Contains(got, fmt.Sprintf("[Math]::Min(%d,", readChunkSize)) {
+		t.Errorf("Want script reads a single chunk, got %q", got)
+	}
+}
+
+func TestPing(t *testing.T) {
+	s := new(stub)
+	server := httptest.NewTLSServer(s)
+	defer server.Close()
+
+	client := New(server.Listener.Addr().String(), "Administrator", "password")
+	if err := client.Ping(nocontext, 5432); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(s.commands) != 1 {
+		t.Errorf("Want a single command, got %d", len(s.commands))
+		return
+	}
+	got := decodeCommand(t, s.commands[0])
+	if !strings.Contains(got, "$client.Connect('127.0.0.1', 5432)") {
+		t.Errorf("Want script connects to the port, got %q", got)
+	}
+}
+
+func TestPing_Error(t *testing.T) {
+	s := &stub{exitCode: 1}
+	server := httptest.NewTLSServer(s)
+	defer server.Close()
+
+	client := New(server.Listener.Addr().String(), "Administrator", "password")
+	if err := client.Ping(nocontext, 5432); err == nil {
+		t.Errorf("Want error when the port does not accept connections")
+	}
+}
+
+// helper function decodes the powershell encoded command.
+func decodeCommand(t *testing.T, command string) string {
+	prefix := "powershell -NoProfile -NonInteractive -EncodedCommand "
+	if !strings.HasPrefix(command, prefix) {
+		t.Errorf("Want encoded powershell command, got %q", command)
+		return ""
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(command, prefix))
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	var runes []uint16
+	for i := 0; i+1 < len(raw); i += 2 {
+		runes = append(runes, uint16(raw[i])|uint16(raw[i+1])<<8)
+	}
+	return string(utf16.Decode(runes))
+}
+
+// stub implements a minimal winrm server for testing
+// purposes. It records all commands and responds to each
+// command with the configured output and exit code.
+type stub struct {
+	sync.Mutex
+	stdout   string
+	exitCode int
+	timeouts int
+	commands []string
+	shells   int
+}
+
+func (s *stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	username, password, _ := r.BasicAuth()
+	if username != "Administrator" || password != "password" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	in := struct {
+		Action  string `xml:"Header>Action"`
+		Command string `xml:"Body>CommandLine>Command"`
+	}{}
+	if err := xml.NewDecoder(r.Body).Decode(&in); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	switch in.Action {
+	case actionCreate:
+		s.shells++
+		fmt.Fprint(w, stubShell)
+	case actionDelete:
+		s.shells--
+		fmt.Fprint(w, stubEmpty)
+	case actionCommand:
+		s.commands = append(s.commands, in.Command)
+		fmt.Fprint(w, stubCommand)
+	case actionReceive:
+		if s.timeouts > 0 {
+			s.timeouts--
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, stubTimeout)
+			return
+		}
+		fmt.Fprintf(w, stubReceive,
+			base64.StdEncoding.EncodeToString([]byte(s.stdout)),
+			s.exitCode,
+		)
+	default:
+		fmt.Fprint(w, stubEmpty)
+	}
+}
+
+const stubEmpty = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body></s:Body></s:Envelope>`
+
+const stubShell = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><s:Body><rsp:Shell><rsp:ShellId>F0D1E2C3-0000-0000-0000-000000000000</rsp:ShellId></rsp:Shell></s:Body></s:Envelope>`
+
+const stubCommand = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><s:Body><rsp:CommandResponse><rsp:CommandId>A1B2C3D4-0000-0000-0000-000000000000</rsp:CommandId></rsp:CommandResponse></s:Body></s:Envelope>`
+
+const stubReceive = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><s:Body><rsp:ReceiveResponse><rsp:Stream Name="stdout" CommandId="A1B2C3D4-0000-0000-0000-000000000000">%s</rsp:Stream><rsp:Stream Name="stdout" CommandId="A1B2C3D4-0000-0000-0000-000000000000" End="true"></rsp:Stream><rsp:CommandState CommandId="A1B2C3D4-0000-0000-0000-000000000000" State="http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"><rsp:ExitCode>%d</rsp:ExitCode></rsp:CommandState></rsp:ReceiveResponse></s:Body></s:Envelope>`
+
+const stubTimeout = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body><s:Fault><s:Code><s:Value>s:Receiver</s:Value></s:Code><s:Reason><s:Text xml:lang="en-US">The WS-Management service cannot complete the operation within the time specified in OperationTimeout.</s:Text></s:Reason><s:Detail><f:WSManFault xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault" Code="2150858793" Machine="localhost"><f:Message></f:Message></f:WSManFault></s:Detail></s:Fault></s:Body></s:Envelope>`